@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type ResetPolicy string
+
+const (
+	ResetNever   ResetPolicy = "never"
+	ResetDaily   ResetPolicy = "daily"
+	ResetMonthly ResetPolicy = "monthly"
+	ResetYearly  ResetPolicy = "yearly"
+)
+
+var resetPolicies = []ResetPolicy{ResetNever, ResetDaily, ResetMonthly, ResetYearly}
+
+// Counter is a persistent sequence, such as a receipt or order number. Value
+// is the last number handed out, and LastUsed decides whether the reset
+// policy has started a new sequence.
+type Counter struct {
+	Name     string      `json:"name"`
+	Value    int         `json:"value"`
+	Prefix   string      `json:"prefix,omitempty"`
+	Padding  int         `json:"padding,omitempty"`
+	Reset    ResetPolicy `json:"reset,omitempty"`
+	LastUsed time.Time   `json:"last_used,omitzero"`
+}
+
+// countersMu guards the counters file within this process, and the lock file
+// guards it against other copies of Receiptify.
+var countersMu sync.Mutex
+
+func countersFile() string {
+	return filepath.Join(filepath.Dir(settingsFile), "counters.json")
+}
+
+// period names the reset period t falls in. Two times in the same period
+// belong to the same sequence.
+func (p ResetPolicy) period(t time.Time) string {
+	t = t.Local()
+	switch p {
+	case ResetDaily:
+		return t.Format("2006-01-02")
+	case ResetMonthly:
+		return t.Format("2006-01")
+	case ResetYearly:
+		return t.Format("2006")
+	}
+	return ""
+}
+
+// current is the counter's value once any reset due by now has happened.
+func (c Counter) current(now time.Time) int {
+	if c.LastUsed.IsZero() || c.Reset.period(c.LastUsed) == c.Reset.period(now) {
+		return c.Value
+	}
+	return 0
+}
+
+// Format writes a value of the counter with its prefix and zero padding.
+func (c Counter) Format(value int) string {
+	return c.Prefix + fmt.Sprintf("%0*d", c.Padding, value)
+}
+
+func loadCounters() ([]Counter, error) {
+	data, err := os.ReadFile(countersFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var counters []Counter
+	if err := json.Unmarshal(data, &counters); err != nil {
+		return nil, fmt.Errorf("counters file is corrupt: %v", err)
+	}
+	return counters, nil
+}
+
+func saveCounters(counters []Counter) error {
+	data, err := json.MarshalIndent(counters, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(countersFile(), data, 0644)
+}
+
+// withCounters runs fn on the stored counters while holding the lock, and
+// saves them afterwards if fn reports a change.
+func withCounters(fn func(counters []Counter) ([]Counter, bool, error)) error {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+
+	return withFileLock(countersFile(), func() error {
+		counters, err := loadCounters()
+		if err != nil {
+			return err
+		}
+		counters, changed, err := fn(counters)
+		if err != nil || !changed {
+			return err
+		}
+		return saveCounters(counters)
+	})
+}
+
+func counterIndex(counters []Counter, name string) int {
+	return slices.IndexFunc(counters, func(c Counter) bool { return c.Name == name })
+}
+
+// NextCounter hands out the next number in a sequence, creating the counter
+// if it doesn't exist yet. For a preview it only says what the next number
+// would be.
+func NextCounter(name string, preview bool) (string, error) {
+	if preview {
+		return PeekCounter(name)
+	}
+
+	var result string
+	err := withCounters(func(counters []Counter) ([]Counter, bool, error) {
+		i := counterIndex(counters, name)
+		if i < 0 {
+			counters = append(counters, Counter{Name: name, Reset: ResetNever})
+			i = len(counters) - 1
+		}
+		now := time.Now()
+		c := &counters[i]
+		c.Value = c.current(now) + 1
+		c.LastUsed = now
+		result = c.Format(c.Value)
+		return counters, true, nil
+	})
+	return result, err
+}
+
+// PeekCounter says what NextCounter would return, without changing anything.
+func PeekCounter(name string) (string, error) {
+	var result string
+	err := withCounters(func(counters []Counter) ([]Counter, bool, error) {
+		c := Counter{Name: name}
+		if i := counterIndex(counters, name); i >= 0 {
+			c = counters[i]
+		}
+		result = c.Format(c.current(time.Now()) + 1)
+		return counters, false, nil
+	})
+	return result, err
+}
+
+// ReplaceCounter saves counter in place of the counter called old, or as a
+// new counter if old is empty, in one step so a rename can't lose it. It
+// refuses to take the name of another counter.
+func ReplaceCounter(old string, counter Counter) error {
+	return withCounters(func(counters []Counter) ([]Counter, bool, error) {
+		if counter.Name != old && counterIndex(counters, counter.Name) >= 0 {
+			return counters, false, fmt.Errorf("there is already a counter called %s", counter.Name)
+		}
+		if i := counterIndex(counters, old); old != "" && i >= 0 {
+			counters[i] = counter
+		} else {
+			counters = append(counters, counter)
+		}
+		return counters, true, nil
+	})
+}
+
+// CreateCounter adds a counter starting after value, unless there is already
+// one with the name. It reports whether the counter was created.
+func CreateCounter(name string, value int) (bool, error) {
+	created := false
+	err := withCounters(func(counters []Counter) ([]Counter, bool, error) {
+		if counterIndex(counters, name) >= 0 {
+			return counters, false, nil
+		}
+		created = true
+		return append(counters, Counter{Name: name, Value: value, Reset: ResetNever}), true, nil
+	})
+	return created, err
+}
+
+func DeleteCounter(name string) error {
+	return withCounters(func(counters []Counter) ([]Counter, bool, error) {
+		i := counterIndex(counters, name)
+		if i < 0 {
+			return counters, false, nil
+		}
+		return slices.Delete(counters, i, i+1), true, nil
+	})
+}
+
+func ListCounters() ([]Counter, error) {
+	var list []Counter
+	err := withCounters(func(counters []Counter) ([]Counter, bool, error) {
+		list = counters
+		return counters, false, nil
+	})
+	return list, err
+}
+
+func showCountersDialog(w fyne.Window) {
+	listContainer := container.NewVBox()
+
+	var refreshList func()
+	refreshList = func() {
+		listContainer.Objects = nil
+		counters, err := ListCounters()
+		if err != nil {
+			listContainer.Add(widget.NewLabel(err.Error()))
+		}
+		now := time.Now()
+		for _, c := range counters {
+			counter := c
+			label := fmt.Sprintf("%s: %s (next %s, resets %s)", counter.Name, counter.Format(counter.Value),
+				counter.Format(counter.current(now)+1), counter.Reset)
+			nameBtn := widget.NewButton(label, func() {
+				showEditCounterDialog(counter, func(updated Counter) error {
+					if err := ReplaceCounter(counter.Name, updated); err != nil {
+						return err
+					}
+					refreshList()
+					return nil
+				}, w)
+			})
+			nameBtn.Alignment = widget.ButtonAlignLeading
+
+			deleteBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Delete Counter", "The next number handed out for "+counter.Name+" will start again from 1. Delete it?", func(confirm bool) {
+					if !confirm {
+						return
+					}
+					if err := DeleteCounter(counter.Name); err != nil {
+						dialog.ShowError(err, w)
+					}
+					refreshList()
+				}, w)
+			})
+
+			listContainer.Add(container.NewBorder(nil, nil, nil, deleteBtn, nameBtn))
+		}
+		if len(counters) == 0 {
+			listContainer.Add(widget.NewLabel("No counters yet. They are created the first time they are used."))
+		}
+		listContainer.Refresh()
+	}
+
+	addBtn := widget.NewButtonWithIcon("Add Counter", theme.ContentAddIcon(), func() {
+		showEditCounterDialog(Counter{Reset: ResetNever}, func(created Counter) error {
+			if err := ReplaceCounter("", created); err != nil {
+				return err
+			}
+			refreshList()
+			return nil
+		}, w)
+	})
+
+	refreshList()
+
+	scroll := container.NewVScroll(listContainer)
+	scroll.SetMinSize(fyne.NewSize(400, 5*40))
+	dialog.ShowCustom("Counters", "Close", container.NewBorder(nil, addBtn, nil, nil, scroll), w)
+}
+
+func showEditCounterDialog(c Counter, onSave func(Counter) error, w fyne.Window) {
+	var editDialog *dialog.CustomDialog
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(c.Name)
+
+	valueEntry := widget.NewEntry()
+	valueEntry.SetText(strconv.Itoa(c.current(time.Now())))
+
+	prefixEntry := widget.NewEntry()
+	prefixEntry.SetText(c.Prefix)
+
+	paddingEntry := widget.NewEntry()
+	paddingEntry.SetText(strconv.Itoa(c.Padding))
+
+	policies := make([]string, len(resetPolicies))
+	for i, p := range resetPolicies {
+		policies[i] = string(p)
+	}
+	resetSelect := widget.NewSelect(policies, func(s string) {})
+	if c.Reset != "" {
+		resetSelect.SetSelected(string(c.Reset))
+	} else {
+		resetSelect.SetSelected(string(ResetNever))
+	}
+
+	form := widget.NewForm(
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Last Number", valueEntry),
+		widget.NewFormItem("Prefix", prefixEntry),
+		widget.NewFormItem("Zero Padding", paddingEntry),
+		widget.NewFormItem("Reset", resetSelect),
+	)
+	form.Items[1].HintText = "The next number handed out will be one more than this"
+
+	saveBtn := widget.NewButton("Save", func() {
+		name := strings.TrimSpace(nameEntry.Text)
+		if name == "" {
+			dialog.ShowInformation("Missing Name", "Please enter a counter name.", w)
+			return
+		}
+		value, err := strconv.Atoi(valueEntry.Text)
+		if err != nil || value < 0 {
+			dialog.ShowError(fmt.Errorf("the last number must be a whole number of 0 or more"), w)
+			return
+		}
+		padding, err := strconv.Atoi(paddingEntry.Text)
+		if err != nil || padding < 0 {
+			padding = 0
+		}
+
+		err = onSave(Counter{
+			Name:     name,
+			Value:    value,
+			Prefix:   prefixEntry.Text,
+			Padding:  padding,
+			Reset:    ResetPolicy(resetSelect.Selected),
+			LastUsed: time.Now(),
+		})
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		editDialog.Hide()
+	})
+
+	editDialog = dialog.NewCustom("Edit Counter", "Cancel", container.NewVBox(form, saveBtn), w)
+	editDialog.Resize(fyne.NewSize(350, 350))
+	editDialog.Show()
+}
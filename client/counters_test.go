@@ -0,0 +1,94 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCounterCurrent(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.ParseInLocation(time.DateTime, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name     string
+		reset    ResetPolicy
+		lastUsed string
+		now      string
+		want     int
+	}{
+		{"never used", ResetDaily, "", "2024-03-01 09:00:00", 7},
+		{"never resets", ResetNever, "2020-01-01 09:00:00", "2024-03-01 09:00:00", 7},
+		{"same day", ResetDaily, "2024-03-01 00:00:00", "2024-03-01 23:59:59", 7},
+		{"next day", ResetDaily, "2024-03-01 23:59:59", "2024-03-02 00:00:00", 0},
+		{"same day a year later", ResetDaily, "2023-03-01 12:00:00", "2024-03-01 12:00:00", 0},
+		{"same month", ResetMonthly, "2024-02-01 00:00:00", "2024-02-29 23:59:59", 7},
+		{"next month", ResetMonthly, "2024-02-29 23:59:59", "2024-03-01 00:00:00", 0},
+		{"same month a year later", ResetMonthly, "2023-02-10 12:00:00", "2024-02-10 12:00:00", 0},
+		{"same year", ResetYearly, "2024-01-01 00:00:00", "2024-12-31 23:59:59", 7},
+		{"next year", ResetYearly, "2024-12-31 23:59:59", "2025-01-01 00:00:00", 0},
+	}
+
+	for _, tt := range tests {
+		c := Counter{Name: "receipt", Value: 7, Reset: tt.reset}
+		if tt.lastUsed != "" {
+			c.LastUsed = at(tt.lastUsed)
+		}
+		if got := c.current(at(tt.now)); got != tt.want {
+			t.Errorf("%s: current = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCounterFormat(t *testing.T) {
+	c := Counter{Prefix: "INV-", Padding: 4}
+	if got := c.Format(42); got != "INV-0042" {
+		t.Errorf("Format(42) = %q, want INV-0042", got)
+	}
+	if got := c.Format(123456); got != "INV-123456" {
+		t.Errorf("Format(123456) = %q, want INV-123456", got)
+	}
+}
+
+func TestReplaceCounter(t *testing.T) {
+	settingsFile = filepath.Join(t.TempDir(), "settings.json")
+	t.Cleanup(func() { settingsFile = "" })
+
+	for _, c := range []Counter{{Name: "a", Value: 1}, {Name: "b", Value: 2}} {
+		if err := ReplaceCounter("", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ReplaceCounter("", Counter{Name: "a"}); err == nil {
+		t.Errorf("adding a second counter called a should fail")
+	}
+	if err := ReplaceCounter("a", Counter{Name: "b", Value: 10}); err == nil {
+		t.Errorf("renaming a onto b should fail")
+	}
+	if err := ReplaceCounter("a", Counter{Name: "c", Value: 5}); err != nil {
+		t.Fatalf("renaming a to c: %v", err)
+	}
+	if err := ReplaceCounter("b", Counter{Name: "b", Value: 20}); err != nil {
+		t.Fatalf("changing b: %v", err)
+	}
+
+	counters, err := ListCounters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Counter{{Name: "c", Value: 5}, {Name: "b", Value: 20}}
+	if len(counters) != len(want) {
+		t.Fatalf("counters = %+v, want %+v", counters, want)
+	}
+	for i := range want {
+		if counters[i].Name != want[i].Name || counters[i].Value != want[i].Value {
+			t.Errorf("counter %d = %+v, want %+v", i, counters[i], want[i])
+		}
+	}
+}
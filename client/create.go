@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"image/color"
 	"io"
-	"strings"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -19,46 +24,284 @@ import (
 var creatorComponents []Component
 var creatorContainer *fyne.Container
 var currentCreatorTemplate string
+var creatorStyles []NamedStyle
+var creatorMeta TemplateMetadata
+var creatorPrinterSelect *widget.Select
+var creatorCopiesEntry *widget.Entry
+
+// creatorVariableValues holds what has been filled in for each of the
+// template's variables.
+var creatorVariables []TemplateVariable
+var creatorVariableValues map[string]string
+var creatorVariablesForm *widget.Form
+
+// creatorVariant is the locale of the variant being filled in, or "" for the
+// template's default layout, which is then kept in creatorDefaultLayout.
+var creatorVariants []TemplateVariant
+var creatorVariant string
+var creatorDefaultLayout []Component
+var creatorVariantSelect *widget.Select
+
+// creatorReceipt is a copy of what has been filled in in the creator. It is
+// taken on the UI goroutine, so the receipt can be expanded away from it while
+// the user carries on editing.
+type creatorReceipt struct {
+	Layout    []Component
+	Styles    []NamedStyle
+	Variables []TemplateVariable
+	Values    map[string]string
+}
 
-func isPluginCall(token string) bool {
-	return strings.HasPrefix(token, "{{") && strings.HasSuffix(token, "}}")
+func snapshotCreatorReceipt() creatorReceipt {
+	return creatorReceipt{
+		Layout:    cloneLayout(creatorComponents),
+		Styles:    slices.Clone(creatorStyles),
+		Variables: slices.Clone(creatorVariables),
+		Values:    maps.Clone(creatorVariableValues),
+	}
 }
 
-func tryExpand(component Component) (string, error) {
-	tokens := strings.Split(component.Content, " ")
-	output := []string{}
+// expandCreatorReceipt fills in the receipt being created, ready to print or
+// preview.
+func expandCreatorReceipt(ctx context.Context, receipt creatorReceipt) ([]Component, error) {
+	env, err := variableEnv(ctx, receipt.Variables, receipt.Values)
+	if err != nil {
+		return nil, err
+	}
+	expandedComponents := []Component{}
+	for _, component := range receipt.Layout {
+		expanded, err := expandComponent(component, env, receipt.Styles)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", component.Name, err)
+		}
+		expandedComponents = append(expandedComponents, expanded...)
+	}
+	return expandedComponents, nil
+}
 
-	for _, token := range tokens {
-		if isPluginCall(token) {
-			callResult, err := RunPlugin(token)
-			if err != nil {
-				return "", err
+// expandWithProgress expands the receipt away from the UI goroutine, so slow
+// plugins don't freeze the app. If it takes a while, a dialog lets the user
+// cancel it. done is called on the UI goroutine unless there was an error or
+// the user cancelled.
+func expandWithProgress(preview bool, w fyne.Window, done func([]Component)) {
+	job := newHostJob(currentCreatorTemplate, creatorPrinter(), creatorCopies(), preview)
+	job.Locale = creatorLocale()
+	receipt := snapshotCreatorReceipt()
+	ctx, cancel := context.WithCancel(withHostJob(context.Background(), job))
+	progress := dialog.NewCustomWithoutButtons("Filling In Receipt", container.NewVBox(
+		widget.NewProgressBarInfinite(),
+		widget.NewButton("Cancel", cancel),
+	), w)
+
+	finished := false
+	time.AfterFunc(300*time.Millisecond, func() {
+		fyne.Do(func() {
+			if !finished {
+				progress.Show()
 			}
+		})
+	})
 
-			output = append(output, callResult...)
-		} else {
-			output = append(output, token)
+	go func() {
+		expanded, err := expandCreatorReceipt(ctx, receipt)
+
+		fyne.Do(func() {
+			finished = true
+			progress.Hide()
+			switch {
+			case ctx.Err() != nil:
+				// cancelled
+			case err != nil:
+				dialog.ShowError(err, w)
+			default:
+				done(expanded)
+			}
+			cancel()
+		})
+	}()
+}
+
+// creatorPrinter is the printer profile chosen in the creator, or "" for the
+// default print server.
+func creatorPrinter() string {
+	if creatorPrinterSelect.Selected == defaultPrinter {
+		return ""
+	}
+	return creatorPrinterSelect.Selected
+}
+
+func creatorCopies() int {
+	copies, err := strconv.Atoi(creatorCopiesEntry.Text)
+	if err != nil || copies < 1 {
+		return 1
+	}
+	return copies
+}
+
+// showExpandedPreview expands the receipt in preview mode, so plugins don't
+// use up references or change their data, and shows how it will print.
+func showExpandedPreview(w fyne.Window) {
+	expandWithProgress(true, w, func(expanded []Component) {
+		showPreviewDialog(expanded, w)
+	})
+}
+
+func showPreviewDialog(expanded []Component, w fyne.Window) {
+	paper := container.NewVBox()
+	for _, c := range expanded {
+		paper.Add(renderComponentPreview(c))
+	}
+	background := canvas.NewRectangle(color.White)
+	scroll := container.NewVScroll(container.NewStack(background, container.NewPadded(paper)))
+	scroll.SetMinSize(fyne.NewSize(340, 500))
+	dialog.ShowCustom("Preview", "Close", scroll, w)
+}
+
+// printExpanded prints a filled in receipt to the printer and number of
+// copies chosen in the creator.
+func printExpanded(expandedComponents []Component, w fyne.Window) {
+	err := PrintCopies(expandedComponents, printerURL(creatorPrinter()), creatorCopies())
+	if err != nil {
+		dialog.ShowError(err, w)
+	} else {
+		dialog.ShowInformation("Printed", "Receipt has been printed!", w)
+	}
+}
+
+// creatorTemplate builds a template from the creator's current state.
+func creatorTemplate() Template {
+	tmpl := Template{
+		SchemaVersion:    CurrentSchemaVersion,
+		Name:             currentCreatorTemplate,
+		TemplateMetadata: creatorMeta,
+		Layout:           creatorComponents,
+		Variants:         slices.Clone(creatorVariants),
+		Styles:           creatorStyles,
+		Variables:        creatorVariables,
+	}
+	if creatorVariant != "" {
+		if i := variantIndex(tmpl.Variants, creatorVariant); i >= 0 {
+			tmpl.Variants[i].Layout = tmpl.Layout
 		}
+		tmpl.Layout = creatorDefaultLayout
 	}
+	return tmpl
+}
 
-	return strings.Join(output, " "), nil
+// creatorLocale is the locale the receipt is being filled in for.
+func creatorLocale() LocaleFormat {
+	if creatorVariant != "" {
+		return localeOrDefault(creatorVariant)
+	}
+	return localeOrDefault(creatorMeta.Locale)
+}
+
+// selectCreatorVariant stores the layout being filled in and shows the layout
+// for another locale, or the default layout if locale is empty.
+func selectCreatorVariant(locale string) {
+	if locale == creatorVariant || (locale != "" && variantIndex(creatorVariants, locale) < 0) {
+		return
+	}
+
+	if creatorVariant == "" {
+		creatorDefaultLayout = creatorComponents
+	} else if i := variantIndex(creatorVariants, creatorVariant); i >= 0 {
+		creatorVariants[i].Layout = creatorComponents
+	}
+
+	next := creatorDefaultLayout
+	if locale != "" {
+		next = creatorVariants[variantIndex(creatorVariants, locale)].Layout
+	}
+	creatorVariant = locale
+	refreshCreatorVariantSelect()
+	showCreatorLayout(next)
 }
 
+func refreshCreatorVariantSelect() {
+	if creatorVariantSelect == nil {
+		return
+	}
+	creatorVariantSelect.Options = variantOptions(creatorVariants)
+	creatorVariantSelect.Selected = defaultVariant
+	if creatorVariant != "" {
+		creatorVariantSelect.Selected = creatorVariant
+	}
+	creatorVariantSelect.Refresh()
+}
+
+// refreshCreatorVariables rebuilds the form for filling in the template's
+// variables. Variables which haven't been filled in start at their default.
+func refreshCreatorVariables() {
+	if creatorVariablesForm == nil {
+		return
+	}
+	creatorVariablesForm.Items = nil
+	for _, v := range creatorVariables {
+		if v.Expression != "" {
+			continue
+		}
+		name := v.Name
+		value, ok := creatorVariableValues[name]
+		if !ok {
+			value = v.Default
+			creatorVariableValues[name] = value
+		}
+		entry := widget.NewEntry()
+		entry.SetText(value)
+		entry.OnChanged = func(s string) {
+			creatorVariableValues[name] = s
+		}
+		creatorVariablesForm.Append(variableLabel(v), entry)
+	}
+	creatorVariablesForm.Refresh()
+}
+
+// LoadTemplateIntoCreator fills in a copy of tmpl, so the library is never
+// changed from the creator.
 func LoadTemplateIntoCreator(tmpl Template) {
+	tmpl = cloneTemplate(tmpl)
 	currentCreatorTemplate = tmpl.Name
-	creatorComponents = make([]Component, len(tmpl.Layout))
-	copy(creatorComponents, tmpl.Layout)
+	creatorStyles = tmpl.Styles
+	creatorVariables = tmpl.Variables
+	creatorVariableValues = map[string]string{}
+	refreshCreatorVariables()
+	creatorMeta = tmpl.TemplateMetadata
+	creatorVariants = tmpl.Variants
+	creatorVariant = ""
+	creatorDefaultLayout = nil
+	refreshCreatorVariantSelect()
+	if creatorPrinterSelect != nil {
+		creatorPrinterSelect.Options = printerNames()
+		if tmpl.Printer != "" {
+			creatorPrinterSelect.SetSelected(tmpl.Printer)
+		} else {
+			creatorPrinterSelect.SetSelected(defaultPrinter)
+		}
+	}
+	if creatorCopiesEntry != nil {
+		creatorCopiesEntry.SetText(strconv.Itoa(max(tmpl.Copies, 1)))
+	}
+	showCreatorLayout(tmpl.Layout)
+}
+
+// showCreatorLayout builds the creator's form for a layout.
+func showCreatorLayout(layout []Component) {
+	creatorComponents = make([]Component, len(layout))
+	copy(creatorComponents, layout)
+	creatorComponents = ensureComponentIDs(creatorComponents)
 	creatorContainer.Objects = nil
 
 	for i, c := range creatorComponents {
 		switch c.Type {
 		case TextComponent:
+			resolved := ResolveStyle(c, creatorStyles)
 			entry := widget.NewEntry()
 			entry.SetText(c.Content)
 			entry.MultiLine = true
 			entry.Wrapping = fyne.TextWrapWord
-			entry.TextStyle.Bold = c.Bold
-			entry.TextStyle.Italic = c.Italic
+			entry.TextStyle.Bold = resolved.Bold
+			entry.TextStyle.Italic = resolved.Italic
 			entry.Resize(fyne.NewSize(300, 30))
 			idx := i
 			entry.OnChanged = func(s string) {
@@ -181,9 +424,8 @@ func CreateUI(w fyne.Window) fyne.CanvasObject {
 			}
 			defer reader.Close()
 
-			var imported Template
-			jsonParser := json.NewDecoder(reader)
-			if err := jsonParser.Decode(&imported); err != nil {
+			imported, err := DecodeTemplate(reader)
+			if err != nil {
 				dialog.ShowError(err, w)
 				return
 			}
@@ -205,10 +447,7 @@ func CreateUI(w fyne.Window) fyne.CanvasObject {
 				return
 			}
 			defer writer.Close()
-			export := Template{
-				Name:   currentCreatorTemplate,
-				Layout: creatorComponents,
-			}
+			export := creatorTemplate()
 			j, err := json.MarshalIndent(export, "", "  ")
 			if err != nil {
 				dialog.ShowError(err, w)
@@ -226,51 +465,85 @@ func CreateUI(w fyne.Window) fyne.CanvasObject {
 		fd.Show()
 	})
 
+	creatorPrinterSelect = widget.NewSelect(printerNames(), func(s string) {})
+	creatorPrinterSelect.SetSelected(defaultPrinter)
+
+	creatorCopiesEntry = widget.NewEntry()
+	creatorCopiesEntry.SetText("1")
+
+	creatorVariantSelect = widget.NewSelect(variantOptions(creatorVariants), func(s string) {
+		if s == defaultVariant {
+			selectCreatorVariant("")
+		} else {
+			selectCreatorVariant(s)
+		}
+	})
+	refreshCreatorVariantSelect()
+
+	creatorVariablesForm = widget.NewForm()
+
 	printBtn := widget.NewButton("Print", func() {
 		if len(creatorComponents) == 0 {
 			dialog.ShowInformation("No Template", "Load a template first.", w)
 			return
 		}
 
-		expandedComponents := []Component{}
-		for _, component := range creatorComponents {
-			if component.Type == TextComponent || component.Type == QRComponent {
-				output, err := tryExpand(component)
-				if err != nil {
-					dialog.ShowError(err, w)
-					return
-				}
-				component.Content = output
-			}
-
-			expandedComponents = append(expandedComponents, component)
+		doPrint := func() {
+			expandWithProgress(false, w, func(expandedComponents []Component) {
+				printExpanded(expandedComponents, w)
+			})
 		}
 
-		err := SendToPrinter(expandedComponents, settings.PrintServerURL)
-		if err != nil {
-			dialog.ShowError(err, w)
-		} else {
-			dialog.ShowInformation("Printed", "Receipt has been printed!", w)
+		lintTmpl := creatorTemplate()
+		lintTmpl.Layout = creatorComponents
+		diags := LintTemplate(lintTmpl)
+		if hasErrors(diags) {
+			dialog.ShowError(fmt.Errorf("this template has problems which must be fixed before printing:\n\n%s", formatDiagnostics(diags)), w)
+			return
 		}
+		if len(diags) > 0 {
+			dialog.ShowConfirm("Print Anyway?", "This template has warnings:\n\n"+formatDiagnostics(diags)+"\nPrint anyway?", func(confirm bool) {
+				if confirm {
+					doPrint()
+				}
+			}, w)
+			return
+		}
+		doPrint()
 	})
 	printBtn.Importance = widget.HighImportance
 
+	previewBtn := widget.NewButton("Preview Expanded", func() {
+		if len(creatorComponents) == 0 {
+			dialog.ShowInformation("No Template", "Load a template first.", w)
+			return
+		}
+		showExpandedPreview(w)
+	})
+
 	buttons := container.NewHBox(loadFromLibraryBtn, loadBtn, exportBtn)
 
 	if len(creatorComponents) != 0 && currentCreatorTemplate != "" {
-		tmpl := Template{
-			Name:   currentCreatorTemplate,
-			Layout: creatorComponents,
-		}
-		LoadTemplateIntoCreator(tmpl)
+		variant := creatorVariant
+		values := creatorVariableValues
+		LoadTemplateIntoCreator(creatorTemplate())
+		selectCreatorVariant(variant)
+		creatorVariableValues = values
+		refreshCreatorVariables()
 	}
 
 	return container.NewVBox(
 		MakeHeaderLabel("Receipt Creator"),
 		templateNameLabel,
 		buttons,
+		widget.NewForm(widget.NewFormItem("Variant", creatorVariantSelect)),
 		widget.NewSeparator(),
 		creatorContainer,
-		container.NewVBox(printBtn),
+		creatorVariablesForm,
+		widget.NewForm(
+			widget.NewFormItem("Printer", creatorPrinterSelect),
+			widget.NewFormItem("Copies", creatorCopiesEntry),
+		),
+		container.NewVBox(previewBtn, printBtn),
 	)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"image/color"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -23,20 +24,155 @@ import (
 var components []ComponentWidget
 var componentContainer *fyne.Container
 var renderedContainer *fyne.Container
+var diagnosticsContainer *fyne.Container
 
 var currentTemplateName string
-
+var currentTemplateStyles []NamedStyle
+var currentTemplateMeta TemplateMetadata
+var currentTemplateVariants []TemplateVariant
+var currentTemplateVariables []TemplateVariable
+
+// currentVariant is the locale of the variant being edited, or "" for the
+// template's default layout. While a variant is being edited the default
+// layout is kept in defaultLayout.
+var currentVariant string
+var defaultLayout []Component
+var variantSelect *widget.Select
+
+// LoadTemplateIntoEditor edits a copy of tmpl, so nothing in the library
+// changes until the template is saved.
 func LoadTemplateIntoEditor(tmpl Template) {
+	tmpl = cloneTemplate(tmpl)
 	components = []ComponentWidget{}
 	currentTemplateName = tmpl.Name
+	currentTemplateStyles = tmpl.Styles
+	currentTemplateMeta = tmpl.TemplateMetadata
+	currentTemplateVariants = tmpl.Variants
+	currentTemplateVariables = tmpl.Variables
+	currentVariant = ""
+	defaultLayout = nil
+	refreshVariantSelect()
 	for _, comp := range tmpl.Layout {
 		addComponent(comp)
 	}
 }
 
+func editorLayout() []Component {
+	var layout []Component
+	for _, c := range components {
+		layout = append(layout, c.Component)
+	}
+	return layout
+}
+
+// editorTemplate builds a template from the editor's current state.
+func editorTemplate() Template {
+	tmpl := Template{
+		SchemaVersion:    CurrentSchemaVersion,
+		Name:             currentTemplateName,
+		TemplateMetadata: currentTemplateMeta,
+		Layout:           editorLayout(),
+		Variants:         slices.Clone(currentTemplateVariants),
+		Styles:           currentTemplateStyles,
+		Variables:        currentTemplateVariables,
+	}
+	if currentVariant != "" {
+		if i := variantIndex(tmpl.Variants, currentVariant); i >= 0 {
+			tmpl.Variants[i].Layout = tmpl.Layout
+		}
+		tmpl.Layout = defaultLayout
+	}
+	return tmpl
+}
+
+// switchEditorVariant stores the layout being edited and loads the layout for
+// another locale, or the default layout if locale is empty.
+func switchEditorVariant(locale string) {
+	if locale == currentVariant {
+		return
+	}
+
+	layout := editorLayout()
+	if currentVariant == "" {
+		defaultLayout = layout
+	} else if i := variantIndex(currentTemplateVariants, currentVariant); i >= 0 {
+		currentTemplateVariants[i].Layout = layout
+	}
+
+	next := defaultLayout
+	if locale != "" {
+		next = currentTemplateVariants[variantIndex(currentTemplateVariants, locale)].Layout
+	}
+
+	currentVariant = locale
+	components = nil
+	for _, c := range next {
+		addComponent(c)
+	}
+	refreshVariantSelect()
+	refreshComponentList()
+}
+
+// renameEditorStyle points the components being edited, in every variant,
+// at a style's new name.
+func renameEditorStyle(old string, new string) {
+	for i := range components {
+		if components[i].Component.Style == old {
+			components[i].Component.Style = new
+		}
+	}
+	renameStyleReferences(defaultLayout, old, new)
+	for i := range currentTemplateVariants {
+		renameStyleReferences(currentTemplateVariants[i].Layout, old, new)
+	}
+}
+
+func refreshVariantSelect() {
+	if variantSelect == nil {
+		return
+	}
+	variantSelect.Options = variantOptions(currentTemplateVariants)
+	variantSelect.Selected = defaultVariant
+	if currentVariant != "" {
+		variantSelect.Selected = currentVariant
+	}
+	variantSelect.Refresh()
+}
+
+func showAddVariantDialog(w fyne.Window) {
+	var available []string
+	for _, code := range localeCodes() {
+		if code != localeOrDefault(currentTemplateMeta.Locale).Code && variantIndex(currentTemplateVariants, code) < 0 {
+			available = append(available, code)
+		}
+	}
+	if len(available) == 0 {
+		dialog.ShowInformation("No Locales", "This template already has a variant for every locale.", w)
+		return
+	}
+
+	localeSelect := widget.NewSelect(available, func(s string) {})
+	localeSelect.SetSelected(available[0])
+
+	dialog.ShowCustomConfirm("Add Locale Variant", "Add", "Cancel", container.NewVBox(
+		widget.NewLabel("The new variant starts as a copy of the layout being edited."),
+		localeSelect,
+	), func(confirm bool) {
+		if !confirm || localeSelect.Selected == "" {
+			return
+		}
+		currentTemplateVariants = append(currentTemplateVariants, TemplateVariant{
+			Locale: localeSelect.Selected,
+			Layout: editorLayout(),
+		})
+		switchEditorVariant(localeSelect.Selected)
+	}, w)
+}
+
 func EditorUI(w fyne.Window) fyne.CanvasObject {
 	componentContainer = container.NewVBox()
 	renderedContainer = container.NewVBox()
+	diagnosticsContainer = container.NewVBox()
 
 	nameEntry := widget.NewEntry()
 	nameEntry.SetPlaceHolder("Template name")
@@ -45,6 +181,39 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 		currentTemplateName = s
 	}
 
+	variantSelect = widget.NewSelect(variantOptions(currentTemplateVariants), func(s string) {
+		if s == defaultVariant {
+			switchEditorVariant("")
+		} else {
+			switchEditorVariant(s)
+		}
+	})
+	refreshVariantSelect()
+
+	addVariantBtn := widget.NewButtonWithIcon("", theme.ContentAddIcon(), func() {
+		showAddVariantDialog(w)
+	})
+
+	removeVariantBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		if currentVariant == "" {
+			dialog.ShowInformation("Default Layout", "The default layout can't be removed.", w)
+			return
+		}
+		locale := currentVariant
+		dialog.ShowConfirm("Remove Variant", "Remove the "+locale+" variant of this template?", func(confirm bool) {
+			if !confirm {
+				return
+			}
+			switchEditorVariant("")
+			if i := variantIndex(currentTemplateVariants, locale); i >= 0 {
+				currentTemplateVariants = append(currentTemplateVariants[:i], currentTemplateVariants[i+1:]...)
+			}
+			refreshVariantSelect()
+		}, w)
+	})
+
+	variantRow := container.NewBorder(nil, nil, widget.NewLabel("Layout"), container.NewHBox(addVariantBtn, removeVariantBtn), variantSelect)
+
 	receiptBorder := canvas.NewRectangle(color.White)
 	receiptBorder.StrokeColor = color.Gray{Y: 100}
 	receiptBorder.StrokeWidth = 2
@@ -108,10 +277,8 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 			return
 		}
 		currentTemplateName = nameEntry.Text
-		export := Template{Name: currentTemplateName}
-		for _, c := range components {
-			export.Layout = append(export.Layout, c.Component)
-		}
+		stampTemplate(&currentTemplateMeta)
+		export := editorTemplate()
 
 		fd := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
 			if err != nil || writer == nil {
@@ -145,21 +312,14 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 			}
 			defer reader.Close()
 
-			var imported Template
-			jsonParser := json.NewDecoder(reader)
-			if err := jsonParser.Decode(&imported); err != nil {
+			imported, err := DecodeTemplate(reader)
+			if err != nil {
 				dialog.ShowError(err, w)
 				return
 			}
 
-			components = nil
-			componentContainer.Objects = nil
-
-			currentTemplateName = imported.Name
+			LoadTemplateIntoEditor(imported)
 			nameEntry.SetText(imported.Name)
-			for _, c := range imported.Layout {
-				addComponent(c)
-			}
 			refreshComponentList()
 		}, w)
 		fd.SetFilter(storage.NewExtensionFileFilter([]string{".json"}))
@@ -169,10 +329,10 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 	printBtn := widget.NewButton("Print", func() {
 		export := []Component{}
 		for _, c := range components {
-			export = append(export, c.Component)
+			export = append(export, ResolveStyle(c.Component, currentTemplateStyles))
 		}
 
-		err := SendToPrinter(export, settings.PrintServerURL)
+		err := PrintCopies(export, printerURL(currentTemplateMeta.Printer), currentTemplateMeta.Copies)
 
 		if err != nil {
 			dialog.ShowError(err, w)
@@ -196,14 +356,8 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 			}
 		}
 		saveTemplate := func() {
-			layout := []Component{}
-			for _, c := range components {
-				layout = append(layout, c.Component)
-			}
-			newTemplate := Template{
-				Name:   currentTemplateName,
-				Layout: layout,
-			}
+			stampTemplate(&currentTemplateMeta)
+			newTemplate := cloneTemplate(editorTemplate())
 			if exists >= 0 {
 				settings.Library[exists] = newTemplate
 			} else {
@@ -228,12 +382,7 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 		var templateButtons []fyne.CanvasObject
 		for _, tmpl := range settings.Library {
 			btn := widget.NewButton(tmpl.Name, func() {
-				components = nil
-				componentContainer.Objects = nil
-				for _, c := range tmpl.Layout {
-					addComponent(c)
-				}
-				currentTemplateName = tmpl.Name
+				LoadTemplateIntoEditor(tmpl)
 				nameEntry.SetText(tmpl.Name)
 				refreshComponentList()
 			})
@@ -247,9 +396,8 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 	})
 
 	clearBtn := widget.NewButton("Clear", func() {
-		components = []ComponentWidget{}
+		LoadTemplateIntoEditor(Template{})
 		nameEntry.SetText("")
-		currentTemplateName = ""
 		refreshComponentList()
 	})
 
@@ -266,9 +414,25 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 		addComponent(c)
 	})
 
-	contentControls := container.NewVBox(MakeHeaderLabel("Content"), addTextBtn, addDividerBtn, addQRBtn, addImageBtn, clearBtn)
+	stylesBtn := widget.NewButton("Template Styles", func() {
+		showStylesDialog("Template Styles", &currentTemplateStyles, refreshComponentList, renameEditorStyle, w)
+	})
+
+	variablesBtn := widget.NewButton("Template Variables", func() {
+		showVariablesDialog(&currentTemplateVariables, refreshDiagnostics, w)
+	})
+
+	functionsBtn := widget.NewButton("Functions", func() {
+		showFunctionsDialog(w)
+	})
+
+	contentControls := container.NewVBox(MakeHeaderLabel("Content"), addTextBtn, addDividerBtn, addQRBtn, addImageBtn, stylesBtn, variablesBtn, functionsBtn, clearBtn)
 	flowControls := container.NewVBox(MakeHeaderLabel("Data"), importBtn, exportBtn, printBtn)
-	libraryControls := container.NewVBox(MakeHeaderLabel("Library"), saveToLibraryBtn, loadFromLibraryBtn)
+	detailsBtn := widget.NewButton("Template Details", func() {
+		showTemplateDetailsDialog(&currentTemplateMeta, refreshDiagnostics, w)
+	})
+
+	libraryControls := container.NewVBox(MakeHeaderLabel("Library"), detailsBtn, saveToLibraryBtn, loadFromLibraryBtn)
 
 	buttons := container.NewGridWithColumns(3,
 		contentControls,
@@ -281,12 +445,18 @@ func EditorUI(w fyne.Window) fyne.CanvasObject {
 	return container.NewVBox(
 		MakeHeaderLabel("Template Builder"),
 		nameEntry,
+		variantRow,
 		receiptBox,
+		widget.NewCard("", "Diagnostics", diagnosticsContainer),
 		buttons,
 	)
 }
 
 func addComponent(c Component) {
+	if c.ID == "" || editorHasComponentID(c.ID) {
+		c.ID = newComponentID()
+	}
+
 	switch c.Type {
 	case TextComponent, MacroComponent, HeaderComponent:
 		entry := widget.NewEntry()
@@ -311,6 +481,15 @@ func addComponent(c Component) {
 	refreshComponentList()
 }
 
+func editorHasComponentID(id string) bool {
+	for _, c := range components {
+		if c.Component.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 func createAlignedText(text string, fontSize int, style fyne.TextStyle, color color.Color, align string) *canvas.Text {
 	txt := canvas.NewText(text, color)
 	txt.TextSize = float32(fontSize)
@@ -404,7 +583,7 @@ func refreshComponentList() {
 	renderedContainer.Objects = nil
 
 	for i := range components {
-		c := components[i].Component
+		c := ResolveStyle(components[i].Component, currentTemplateStyles)
 
 		var editorWidget fyne.CanvasObject
 		switch c.Type {
@@ -446,7 +625,7 @@ func refreshComponentList() {
 		})
 
 		editBtn := widget.NewButtonWithIcon("", theme.SettingsIcon(), func() {
-			showEditDialog(c, &components[i])
+			showEditDialog(components[i].Component, &components[i])
 		})
 
 		deleteBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
@@ -465,42 +644,73 @@ func refreshComponentList() {
 		components[i].Widget = row
 		componentContainer.Add(row)
 
-		var preview fyne.CanvasObject
-		switch c.Type {
-		case TextComponent, HeaderComponent, MacroComponent:
-			style := fyne.TextStyle{Bold: c.Bold, Italic: c.Italic}
-			fontSizeValue, err := strconv.Atoi(c.FontSize)
-			if err != nil {
-				fontSizeValue = 14
-			}
-			preview = wrapTextLines(c.Content, fontSizeValue, 300, style, color.Black, c.Align)
-		case DividerComponent:
-			line := canvas.NewRectangle(color.Black)
-			line.SetMinSize(fyne.NewSize(300, float32(c.LineWidth)))
-			preview = line
-		case QRComponent:
-			preview = renderQRCode(c)
-		case ImageComponent:
-			if c.Content != "" {
-				// Decode base64 into image
-				data, err := base64.StdEncoding.DecodeString(c.Content)
-				if err == nil {
-					res := canvas.NewImageFromReader(bytes.NewReader(data), c.Name)
-					res.FillMode = canvas.ImageFillContain
-					res.SetMinSize(fyne.NewSize(200, 150))
-					preview = res
-				} else {
-					preview = canvas.NewText("Invalid Image", color.RGBA{255, 0, 0, 255})
-				}
+		renderedContainer.Add(renderComponentPreview(c))
+	}
+
+	componentContainer.Refresh()
+	renderedContainer.Refresh()
+	refreshDiagnostics()
+}
+
+// renderComponentPreview draws a component as it will look on paper.
+func renderComponentPreview(c Component) fyne.CanvasObject {
+	var preview fyne.CanvasObject
+	switch c.Type {
+	case TextComponent, HeaderComponent, MacroComponent:
+		style := fyne.TextStyle{Bold: c.Bold, Italic: c.Italic}
+		fontSizeValue, err := strconv.Atoi(c.FontSize)
+		if err != nil {
+			fontSizeValue = 14
+		}
+		preview = wrapTextLines(c.Content, fontSizeValue, 300, style, color.Black, c.Align)
+	case DividerComponent:
+		line := canvas.NewRectangle(color.Black)
+		line.SetMinSize(fyne.NewSize(300, float32(c.LineWidth)))
+		preview = line
+	case QRComponent:
+		preview = renderQRCode(c)
+	case ImageComponent:
+		if c.Content != "" {
+			// Decode base64 into image
+			data, err := base64.StdEncoding.DecodeString(c.Content)
+			if err == nil {
+				res := canvas.NewImageFromReader(bytes.NewReader(data), c.Name)
+				res.FillMode = canvas.ImageFillContain
+				res.SetMinSize(fyne.NewSize(200, 150))
+				preview = res
 			} else {
-				preview = canvas.NewText("No image selected", color.Gray{Y: 128})
+				preview = canvas.NewText("Invalid Image", color.RGBA{255, 0, 0, 255})
 			}
+		} else {
+			preview = canvas.NewText("No image selected", color.Gray{Y: 128})
 		}
-		renderedContainer.Add(preview)
 	}
+	return preview
+}
 
-	componentContainer.Refresh()
-	renderedContainer.Refresh()
+func refreshDiagnostics() {
+	tmpl := editorTemplate()
+	tmpl.Layout = editorLayout()
+	diagnosticsContainer.Objects = []fyne.CanvasObject{
+		makeDiagnosticsList(LintTemplate(tmpl), tmpl.Layout),
+	}
+	diagnosticsContainer.Refresh()
+}
+
+// appendRepeatItems adds the fields for printing a component once for each
+// item in a list.
+func appendRepeatItems(form *widget.Form, c Component) (*widget.Entry, *widget.Entry) {
+	repeatEntry := widget.NewEntry()
+	repeatEntry.SetPlaceHolder("e.g. order.items")
+	repeatEntry.SetText(c.Repeat)
+
+	repeatAsEntry := widget.NewEntry()
+	repeatAsEntry.SetPlaceHolder("item")
+	repeatAsEntry.SetText(c.RepeatAs)
+
+	form.Append("Repeat For Each", repeatEntry)
+	form.Append("Repeat As", repeatAsEntry)
+	return repeatEntry, repeatAsEntry
 }
 
 func showEditDialog(c Component, wrapper *ComponentWidget) {
@@ -512,6 +722,8 @@ func showEditDialog(c Component, wrapper *ComponentWidget) {
 
 	switch c.Type {
 	case TextComponent, HeaderComponent, MacroComponent:
+		resolved := ResolveStyle(c, currentTemplateStyles)
+
 		textEntry := widget.NewEntry()
 		textEntry.SetText(c.Content)
 
@@ -519,23 +731,41 @@ func showEditDialog(c Component, wrapper *ComponentWidget) {
 		nameEntry.SetText(c.Name)
 
 		fontSize := widget.NewEntry()
-		fontSize.SetText(c.FontSize)
+		fontSize.SetText(resolved.FontSize)
 
 		bold := widget.NewCheck("Bold", nil)
-		bold.SetChecked(c.Bold)
+		bold.SetChecked(resolved.Bold)
 
 		italic := widget.NewCheck("Italic", nil)
-		italic.SetChecked(c.Italic)
+		italic.SetChecked(resolved.Italic)
 
 		underline := widget.NewCheck("Underline", nil)
-		underline.SetChecked(c.Underline)
+		underline.SetChecked(resolved.Underline)
 
 		alignSelect := widget.NewSelect([]string{"left", "center", "right"}, func(s string) {})
-		alignSelect.SetSelected(c.Align)
+		alignSelect.SetSelected(resolved.Align)
 
 		typeOverrideSelect := widget.NewSelect([]string{"text", "header", "macro"}, func(s string) {})
 		typeOverrideSelect.Selected = string(c.Type)
 
+		styleSelect := widget.NewSelect(availableStyleNames(currentTemplateStyles), func(name string) {
+			style, ok := findStyle(name, currentTemplateStyles)
+			if !ok {
+				return
+			}
+			fontSize.SetText(style.FontSize)
+			bold.SetChecked(style.Bold)
+			italic.SetChecked(style.Italic)
+			underline.SetChecked(style.Underline)
+			alignSelect.SetSelected(style.Align)
+		})
+		if c.Style != "" {
+			styleSelect.Selected = c.Style
+		} else {
+			styleSelect.Selected = noStyle
+		}
+
+		form.Append("Style", styleSelect)
 		form.Append("Alignment", alignSelect)
 		form.Append("Text", textEntry)
 		form.Append("Name", nameEntry)
@@ -544,6 +774,7 @@ func showEditDialog(c Component, wrapper *ComponentWidget) {
 		form.Append("", bold)
 		form.Append("", italic)
 		form.Append("", underline)
+		repeatEntry, repeatAsEntry := appendRepeatItems(form, c)
 
 		saveBtn := widget.NewButton("Save", func() {
 			fs := fontSize.Text
@@ -561,6 +792,15 @@ func showEditDialog(c Component, wrapper *ComponentWidget) {
 			updated.Underline = underline.Checked
 			updated.Align = alignSelect.Selected
 			updated.Type = ComponentType(typeOverrideSelect.Selected)
+			updated.Repeat = repeatEntry.Text
+			updated.RepeatAs = repeatAsEntry.Text
+
+			updated.Style = ""
+			updated.Overrides = nil
+			if style, ok := findStyle(styleSelect.Selected, currentTemplateStyles); ok {
+				updated.Style = style.Name
+				updated.Overrides = styleOverrides(updated, style)
+			}
 
 			*wrapper = ComponentWidget{Component: updated}
 			refreshComponentList()
@@ -626,12 +866,15 @@ func showEditDialog(c Component, wrapper *ComponentWidget) {
 		form.Append("Alignment", alignSelect)
 		form.Append("", fitCheck)
 		form.Append("Scale (%)", scaleEntry)
+		repeatEntry, repeatAsEntry := appendRepeatItems(form, c)
 
 		saveBtn := widget.NewButton("Save", func() {
 			updated.Content = contentEntry.Text
 			updated.Name = nameEntry.Text
 			updated.Align = alignSelect.Selected
 			updated.Fit = fitCheck.Checked
+			updated.Repeat = repeatEntry.Text
+			updated.RepeatAs = repeatAsEntry.Text
 			if !fitCheck.Checked {
 				scale, err := strconv.Atoi(scaleEntry.Text)
 				if err != nil || scale <= 0 {
@@ -712,6 +955,11 @@ func showEditDialog(c Component, wrapper *ComponentWidget) {
 		content = container.NewVBox(form, saveBtn)
 	}
 
+	idLabel := widget.NewLabel(c.ID)
+	idLabel.TextStyle.Monospace = true
+	idLabel.Selectable = true
+	form.Append("ID", idLabel)
+
 	editDialog = dialog.NewCustom("Edit Component", "Cancel", content, fyne.CurrentApp().Driver().AllWindows()[0])
 	editDialog.Resize(fyne.NewSize(300, 300))
 	editDialog.Show()
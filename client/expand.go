@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// expandableField is a string field of a component which goes through
+// placeholder expansion, named by its JSON name.
+type expandableField struct {
+	Name  string
+	Value *string
+}
+
+// expandableFields lists every string field of a component, apart from those
+// tagged `expand:"-"`, so fields added later are expanded without changes
+// here.
+func expandableFields(c *Component) []expandableField {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var fields []expandableField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Type != reflect.TypeFor[string]() || f.Tag.Get("expand") == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, expandableField{Name: name, Value: v.Field(i).Addr().Interface().(*string)})
+	}
+	return fields
+}
+
+// hasPlaceholders reports whether text contains any placeholders. Text which
+// can't be parsed counts as having them, since it's meant to.
+func hasPlaceholders(text string) bool {
+	if !strings.Contains(text, "{{") {
+		return false
+	}
+	segments, err := ParseTemplateText(text)
+	if err != nil {
+		return true
+	}
+	return slices.ContainsFunc(segments, func(s Segment) bool {
+		return s.Kind == PlaceholderSegment
+	})
+}
+
+// expandText replaces the placeholders in text with their values.
+func expandText(text string, env *exprEnv) (string, error) {
+	if !strings.ContainsAny(text, "{}") {
+		return text, nil
+	}
+	segments, err := ParseTemplateText(text)
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	for _, segment := range segments {
+		if segment.Kind == TextSegment {
+			output.WriteString(segment.Text)
+			continue
+		}
+
+		expr, err := parseExpression(segment)
+		if err != nil {
+			return "", err
+		}
+		value, err := evalExpr(expr, env)
+		if err != nil {
+			return "", err
+		}
+		output.WriteString(env.locale().toText(value))
+	}
+
+	return output.String(), nil
+}
+
+// maxGenerateDepth stops plugins whose generated components generate more
+// components from going on forever.
+const maxGenerateDepth = 8
+
+// expandComponent resolves a component's style and expands the placeholders
+// in every field. A component with a repeat is printed once for each item in
+// the list, with the item bound to its RepeatAs name (or "item") and loop
+// holding its position. A component whose content calls a function returning
+// components is replaced by the components it returns.
+func expandComponent(component Component, env *exprEnv, styles []NamedStyle) ([]Component, error) {
+	return expandComponentAt(component, env, styles, 0)
+}
+
+func expandComponentAt(component Component, env *exprEnv, styles []NamedStyle, depth int) ([]Component, error) {
+	component = ResolveStyle(component, styles)
+	if component.Repeat == "" {
+		return expandOne(component, env, styles, depth)
+	}
+
+	expr, err := parseSource(component.Repeat)
+	if err != nil {
+		return nil, fmt.Errorf("repeat: %v", err)
+	}
+	value, err := evalExpr(expr, env)
+	if err != nil {
+		return nil, fmt.Errorf("repeat: %v", err)
+	}
+	var items []any
+	switch v := value.(type) {
+	case []any:
+		items = v
+	case nil:
+	default:
+		return nil, fmt.Errorf("repeat: expected a list but found %s", describeValue(value))
+	}
+
+	var expanded []Component
+	for i, item := range items {
+		itemEnv := env.with(repeatName(component), item).with("loop", map[string]any{
+			"index": float64(i + 1),
+			"count": float64(len(items)),
+			"first": i == 0,
+			"last":  i == len(items)-1,
+		})
+		cs, err := expandOne(component, itemEnv, styles, depth)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i+1, err)
+		}
+		expanded = append(expanded, cs...)
+	}
+	return expanded, nil
+}
+
+func expandOne(c Component, env *exprEnv, styles []NamedStyle, depth int) ([]Component, error) {
+	c.Repeat = ""
+	c.RepeatAs = ""
+
+	pluginsMu.Lock()
+	call, ok := componentsCall(c)
+	pluginsMu.Unlock()
+	if ok {
+		if depth >= maxGenerateDepth {
+			return nil, fmt.Errorf("components are generated more than %d levels deep", maxGenerateDepth)
+		}
+		generated, err := generateComponents(call, env, styles)
+		if err != nil {
+			return nil, err
+		}
+		var expanded []Component
+		for i, g := range generated {
+			cs, err := expandComponentAt(g, env, styles, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("generated component %d: %v", i+1, err)
+			}
+			expanded = append(expanded, cs...)
+		}
+		return expanded, nil
+	}
+
+	for _, field := range expandableFields(&c) {
+		output, err := expandText(*field.Value, env)
+		if err != nil {
+			if field.Name == "content" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %v", field.Name, err)
+		}
+		*field.Value = output
+	}
+	if err := checkExpanded(c); err != nil {
+		return nil, err
+	}
+	return []Component{c}, nil
+}
+
+// checkExpanded checks the fields the print server expects particular values
+// in, since they may have been worked out by placeholders.
+func checkExpanded(c Component) error {
+	if !slices.Contains(alignments, c.Align) {
+		return fmt.Errorf("align: %q is not left, center or right", c.Align)
+	}
+	if c.FontSize != "" && c.FontSize != "fit" {
+		if size, err := strconv.Atoi(c.FontSize); err != nil || size <= 0 {
+			return fmt.Errorf("font_size: %q is not a size", c.FontSize)
+		}
+	}
+	return nil
+}
+
+func repeatName(c Component) string {
+	if c.RepeatAs != "" {
+		return c.RepeatAs
+	}
+	return "item"
+}
+
+func returnsComponents(funcInfo *FunctionInfo) bool {
+	return slices.ContainsFunc(funcInfo.Returns, func(r ReturnInfo) bool { return r.Type == "components" })
+}
+
+// componentsCall finds a component whose content is nothing but a call to a
+// plugin function returning components.
+func componentsCall(c Component) (*callExpr, bool) {
+	if !strings.Contains(c.Content, "{{") {
+		return nil, false
+	}
+	segments, err := ParseTemplateText(c.Content)
+	if err != nil {
+		return nil, false
+	}
+
+	var call *callExpr
+	for _, segment := range segments {
+		if segment.Kind == TextSegment {
+			if strings.TrimSpace(segment.Text) != "" {
+				return nil, false
+			}
+			continue
+		}
+		if call != nil {
+			return nil, false
+		}
+		expr, err := parseExpression(segment)
+		if err != nil {
+			return nil, false
+		}
+		var ok bool
+		if call, ok = expr.(*callExpr); !ok {
+			return nil, false
+		}
+	}
+	if call == nil {
+		return nil, false
+	}
+	funcInfo, err := lookupFunction(call.Plugin, call.Function)
+	if err != nil || !returnsComponents(funcInfo) {
+		return nil, false
+	}
+	return call, true
+}
+
+// generateComponents calls a plugin function returning components, and
+// checks each one is a valid component.
+func generateComponents(call *callExpr, env *exprEnv, styles []NamedStyle) ([]Component, error) {
+	value, err := evalExpr(call, env)
+	if err != nil {
+		return nil, err
+	}
+	var items []any
+	switch v := value.(type) {
+	case []any:
+		items = v
+	case map[string]any:
+		if len(v) > 0 {
+			return nil, evalErrorf(call, "%s.%s must return a list of components", call.Plugin, call.Function)
+		}
+	case nil:
+	default:
+		return nil, evalErrorf(call, "%s.%s must return a list of components but returned %s", call.Plugin, call.Function, describeValue(value))
+	}
+
+	variables := slices.Collect(maps.Keys(env.Vars))
+	generated := make([]Component, 0, len(items))
+	for i, item := range items {
+		c, err := componentFromValue(item)
+		if err != nil {
+			return nil, evalErrorf(call, "generated component %d: %v", i+1, err)
+		}
+		if c.ID == "" {
+			c.ID = newComponentID()
+		}
+		pluginsMu.Lock()
+		diags := lintComponent(i, c, styles, variables)
+		pluginsMu.Unlock()
+		for _, d := range diags {
+			if d.Severity == SeverityError {
+				return nil, evalErrorf(call, "generated component %d: %s", i+1, d.Message)
+			}
+		}
+		generated = append(generated, c)
+	}
+	return generated, nil
+}
+
+// componentFromValue converts a table returned by a plugin into a Component,
+// rejecting fields which don't exist. Numbers are accepted for text fields
+// such as font_size.
+func componentFromValue(value any) (Component, error) {
+	table, ok := value.(map[string]any)
+	if !ok {
+		return Component{}, fmt.Errorf("expected a table but found %s", describeValue(value))
+	}
+
+	var probe Component
+	fields := map[string]bool{}
+	for _, f := range expandableFields(&probe) {
+		fields[f.Name] = true
+	}
+	normalized := make(map[string]any, len(table))
+	for k, v := range table {
+		if n, isNumber := v.(float64); isNumber && fields[k] {
+			v = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		normalized[k] = v
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return Component{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var c Component
+	if err := decoder.Decode(&c); err != nil {
+		return Component{}, err
+	}
+	if c.Type == "" {
+		return Component{}, fmt.Errorf("component has no type")
+	}
+	return c, nil
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed placeholder expression.
+type Expr interface {
+	Pos() (int, int)
+}
+
+type position struct {
+	Line   int
+	Column int
+}
+
+func (p position) Pos() (int, int) {
+	return p.Line, p.Column
+}
+
+type literalExpr struct {
+	position
+	Value any
+}
+
+type variableExpr struct {
+	position
+	Name string
+}
+
+// memberExpr is value.Name or value[Index]. Exactly one of Name and Index
+// is set.
+type memberExpr struct {
+	position
+	Value Expr
+	Name  string
+	Index Expr
+}
+
+type callExpr struct {
+	position
+	Plugin   string
+	Function string
+	Args     []Expr
+}
+
+type unaryExpr struct {
+	position
+	Op      string
+	Operand Expr
+}
+
+type binaryExpr struct {
+	position
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+type filterExpr struct {
+	position
+	Name  string
+	Input Expr
+	Args  []Expr
+}
+
+// binaryPrecedence gives how tightly each binary operator binds. Filters
+// bind more loosely than all of them.
+var binaryPrecedence = map[string]int{
+	"or":  1,
+	"and": 2,
+	"==":  3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"~": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// keywords can't be used as variable names.
+var keywords = []string{"true", "false", "nil", "and", "or", "not"}
+
+type exprParser struct {
+	ts *tokenStream
+}
+
+func isPunctToken(t token, text string) bool {
+	return t.Kind == tokenPunct && t.Text == text
+}
+
+func parseError(t token, format string, args ...any) *ParseError {
+	return &ParseError{Line: t.Line, Column: t.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseExpression parses the source of a placeholder.
+func parseExpression(segment Segment) (Expr, error) {
+	tokens, err := lexPlaceholder(segment)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{ts: &tokenStream{tokens: tokens}}
+	if t := p.ts.peek(); t.Kind == tokenEOF {
+		return nil, parseError(t, "placeholder is empty")
+	}
+
+	expr, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.ts.next(); t.Kind != tokenEOF {
+		return nil, parseError(t, "unexpected %s", t.describe())
+	}
+	return expr, nil
+}
+
+// parsePipeline parses an expression followed by any number of
+// "| filter:arg:arg" filters.
+func (p *exprParser) parsePipeline() (Expr, error) {
+	expr, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.ts.isPunct("|") {
+		p.ts.next()
+		name, err := p.ts.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		filter := &filterExpr{position: position{name.Line, name.Column}, Name: name.Text, Input: expr}
+		for p.ts.isPunct(":") {
+			p.ts.next()
+			arg, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			filter.Args = append(filter.Args, arg)
+		}
+		expr = filter
+	}
+	return expr, nil
+}
+
+func (p *exprParser) peekOperator() (string, int) {
+	t := p.ts.peek()
+	if t.Kind != tokenPunct && t.Kind != tokenIdent {
+		return "", 0
+	}
+	return t.Text, binaryPrecedence[t.Text]
+}
+
+func (p *exprParser) parseBinary(minPrecedence int) (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, precedence := p.peekOperator()
+		if precedence == 0 || precedence <= minPrecedence {
+			return left, nil
+		}
+		t := p.ts.next()
+		right, err := p.parseBinary(precedence)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{position: position{t.Line, t.Column}, Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	t := p.ts.peek()
+	switch {
+	case t.Kind == tokenPunct && t.Text == "-":
+		p.ts.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{position: position{t.Line, t.Column}, Op: "-", Operand: operand}, nil
+
+	case t.Kind == tokenIdent && t.Text == "not":
+		// not applies to a whole comparison, so "not a == b" is "not (a == b)".
+		p.ts.next()
+		operand, err := p.parseBinary(binaryPrecedence["and"])
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{position: position{t.Line, t.Column}, Op: "not", Operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a value followed by any number of .field and [index]
+// lookups.
+func (p *exprParser) parsePostfix() (Expr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.ts.isPunct("."):
+			dot := p.ts.next()
+			name, err := p.ts.expectIdent()
+			if err != nil {
+				return nil, err
+			}
+			expr = &memberExpr{position: position{dot.Line, dot.Column}, Value: expr, Name: name.Text}
+		case p.ts.isPunct("["):
+			bracket := p.ts.next()
+			index, err := p.parsePipeline()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.ts.expectPunct("]"); err != nil {
+				return nil, err
+			}
+			expr = &memberExpr{position: position{bracket.Line, bracket.Column}, Value: expr, Index: index}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	t := p.ts.next()
+	pos := position{t.Line, t.Column}
+
+	switch t.Kind {
+	case tokenNumber, tokenString:
+		return &literalExpr{position: pos, Value: t.Value}, nil
+
+	case tokenIdent:
+		switch t.Text {
+		case "true", "false":
+			return &literalExpr{position: pos, Value: t.Text == "true"}, nil
+		case "nil":
+			return &literalExpr{position: pos}, nil
+		}
+		// Plugin.function( is a call, anything else starting with a name is
+		// a variable.
+		if !p.ts.isPunct(".") || p.ts.peekAt(1).Kind != tokenIdent || !isPunctToken(p.ts.peekAt(2), "(") {
+			return &variableExpr{position: pos, Name: t.Text}, nil
+		}
+		p.ts.next()
+		function := p.ts.next()
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		return &callExpr{position: pos, Plugin: t.Text, Function: function.Text, Args: args}, nil
+
+	case tokenPunct:
+		if t.Text == "(" {
+			expr, err := p.parsePipeline()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.ts.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	}
+	return nil, parseError(t, "expected a value but found %s", t.describe())
+}
+
+func (p *exprParser) parseArgs() ([]Expr, error) {
+	if _, err := p.ts.expectPunct("("); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	for !p.ts.isPunct(")") {
+		arg, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.ts.isPunct(",") {
+			break
+		}
+		p.ts.next()
+	}
+	if _, err := p.ts.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// walkExpr calls fn for e and every expression inside it.
+func walkExpr(e Expr, fn func(Expr)) {
+	fn(e)
+	switch e := e.(type) {
+	case *callExpr:
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	case *memberExpr:
+		walkExpr(e.Value, fn)
+		if e.Index != nil {
+			walkExpr(e.Index, fn)
+		}
+	case *unaryExpr:
+		walkExpr(e.Operand, fn)
+	case *binaryExpr:
+		walkExpr(e.Left, fn)
+		walkExpr(e.Right, fn)
+	case *filterExpr:
+		walkExpr(e.Input, fn)
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	}
+}
+
+// exprEnv holds the values expressions can refer to. Variables which aren't
+// set evaluate to nil. Plugin calls stop early if ctx is cancelled.
+type exprEnv struct {
+	Vars map[string]any
+	ctx  context.Context
+}
+
+// locale is the locale values are written in and read with.
+func (env *exprEnv) locale() LocaleFormat {
+	return localeFrom(env.ctx)
+}
+
+func (env *exprEnv) context() context.Context {
+	if env.ctx == nil {
+		return context.Background()
+	}
+	return env.ctx
+}
+
+// with returns a copy of env with one more variable set.
+func (env *exprEnv) with(name string, value any) *exprEnv {
+	vars := make(map[string]any, len(env.Vars)+1)
+	for k, v := range env.Vars {
+		vars[k] = v
+	}
+	vars[name] = value
+	return &exprEnv{Vars: vars, ctx: env.ctx}
+}
+
+// parseSource parses an expression written on its own, outside of {{ }},
+// such as a variable's expression or a component's repeat.
+func parseSource(source string) (Expr, error) {
+	return parseExpression(Segment{Kind: PlaceholderSegment, Text: source, Line: 1, Column: 1})
+}
+
+func evalErrorf(e Expr, format string, args ...any) error {
+	line, column := e.Pos()
+	return fmt.Errorf("line %d, column %d: %s", line, column, fmt.Sprintf(format, args...))
+}
+
+// evalExpr works out the value of an expression. Values are strings,
+// float64s, bools, nil, or []any lists and map[string]any tables of them.
+func evalExpr(e Expr, env *exprEnv) (any, error) {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.Value, nil
+
+	case *variableExpr:
+		return env.Vars[e.Name], nil
+
+	case *callExpr:
+		call := pluginCall{Plugin: e.Plugin, Function: e.Function, Line: e.Line, Column: e.Column}
+		for _, arg := range e.Args {
+			value, err := evalExpr(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, value)
+		}
+		if e.Plugin == stdNamespace {
+			value, err := callStd(env.context(), e.Function, call.Args)
+			if err != nil {
+				return nil, evalErrorf(e, "%s.%s: %v", e.Plugin, e.Function, err)
+			}
+			return value, nil
+		}
+		rets, err := RunPlugin(env.context(), call)
+		if err != nil {
+			return nil, evalErrorf(e, "%v", err)
+		}
+		switch len(rets) {
+		case 0:
+			return nil, nil
+		case 1:
+			return rets[0], nil
+		}
+		return rets, nil
+
+	case *memberExpr:
+		value, err := evalExpr(e.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		if e.Index == nil {
+			return lookupMember(env.locale(), e, value, e.Name)
+		}
+		index, err := evalExpr(e.Index, env)
+		if err != nil {
+			return nil, err
+		}
+		return lookupMember(env.locale(), e, value, index)
+
+	case *unaryExpr:
+		value, err := evalExpr(e.Operand, env)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "not" {
+			return !truthy(value), nil
+		}
+		n, err := env.locale().toNumber(value)
+		if err != nil {
+			return nil, evalErrorf(e, "%v", err)
+		}
+		return -n, nil
+
+	case *binaryExpr:
+		return evalBinary(e, env)
+
+	case *filterExpr:
+		filter, ok := filters[e.Name]
+		if !ok {
+			return nil, evalErrorf(e, "unknown filter %s", e.Name)
+		}
+		input, err := evalExpr(e.Input, env)
+		if err != nil {
+			return nil, err
+		}
+		var args []any
+		for _, arg := range e.Args {
+			value, err := evalExpr(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		if err := filter.checkArgs(len(args)); err != nil {
+			return nil, evalErrorf(e, "%s: %v", e.Name, err)
+		}
+		result, err := filter.Apply(env.locale(), input, args)
+		if err != nil {
+			return nil, evalErrorf(e, "%s: %v", e.Name, err)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+// lookupMember gets a field of a table, or an item of a list. Lists are
+// numbered from 1, as they are in Lua. Missing fields and items are nil.
+func lookupMember(locale LocaleFormat, e Expr, value any, key any) (any, error) {
+	switch value := value.(type) {
+	case map[string]any:
+		return value[locale.toText(key)], nil
+	case []any:
+		n, err := locale.toNumber(key)
+		if err != nil {
+			return nil, evalErrorf(e, "list index: %v", err)
+		}
+		i := int(n)
+		if float64(i) != n || i < 1 || i > len(value) {
+			return nil, nil
+		}
+		return value[i-1], nil
+	case nil:
+		return nil, nil
+	}
+	return nil, evalErrorf(e, "cannot look up %s in %s", locale.toText(key), describeValue(value))
+}
+
+func evalBinary(e *binaryExpr, env *exprEnv) (any, error) {
+	left, err := evalExpr(e.Left, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	// As in Lua, and and or give back one of their operands, so
+	// "paid and 'PAID' or 'DUE'" picks between two values.
+	case "and":
+		if !truthy(left) {
+			return left, nil
+		}
+		return evalExpr(e.Right, env)
+	case "or":
+		if truthy(left) {
+			return left, nil
+		}
+		return evalExpr(e.Right, env)
+	}
+
+	right, err := evalExpr(e.Right, env)
+	if err != nil {
+		return nil, err
+	}
+
+	locale := env.locale()
+	switch e.Op {
+	case "~":
+		return locale.toText(left) + locale.toText(right), nil
+	case "==":
+		return compareValues(locale, left, right) == 0, nil
+	case "!=":
+		return compareValues(locale, left, right) != 0, nil
+	case "<":
+		return compareValues(locale, left, right) < 0, nil
+	case "<=":
+		return compareValues(locale, left, right) <= 0, nil
+	case ">":
+		return compareValues(locale, left, right) > 0, nil
+	case ">=":
+		return compareValues(locale, left, right) >= 0, nil
+	}
+
+	l, err := locale.toNumber(left)
+	if err != nil {
+		return nil, evalErrorf(e, "%v", err)
+	}
+	r, err := locale.toNumber(right)
+	if err != nil {
+		return nil, evalErrorf(e, "%v", err)
+	}
+	switch e.Op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return nil, evalErrorf(e, "division by zero")
+		}
+		if e.Op == "%" {
+			return math.Mod(l, r), nil
+		}
+		return l / r, nil
+	}
+	return nil, evalErrorf(e, "unknown operator %s", e.Op)
+}
+
+// toText writes a value the way it appears on a receipt.
+func (l LocaleFormat) toText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return l.FormatPlainNumber(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		texts := make([]string, len(v))
+		for i, item := range v {
+			texts[i] = l.toText(item)
+		}
+		return strings.Join(texts, " ")
+	case map[string]any:
+		keys := slices.Sorted(maps.Keys(v))
+		texts := make([]string, len(keys))
+		for i, k := range keys {
+			texts[i] = k + ": " + l.toText(v[k])
+		}
+		return strings.Join(texts, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func describeValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "an empty value"
+	case string:
+		return "the text " + strconv.Quote(v)
+	case float64:
+		return "the number " + fallbackLocale.toText(v)
+	case bool:
+		return "a boolean"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "a table"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toNumber converts a value to a number. Text is read either as a plain
+// number or with the locale's separators, so "2,50" works in de-DE.
+func (l LocaleFormat) toNumber(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		text := strings.TrimSpace(v)
+		if n, err := strconv.ParseFloat(text, 64); err == nil {
+			return n, nil
+		}
+		local := strings.ReplaceAll(text, l.ThousandsSeparator, "")
+		local = strings.Replace(local, l.DecimalSeparator, ".", 1)
+		if n, err := strconv.ParseFloat(local, 64); err == nil {
+			return n, nil
+		}
+		return 0, fmt.Errorf("%s is not a number", strconv.Quote(v))
+	default:
+		return 0, fmt.Errorf("expected a number but found %s", describeValue(v))
+	}
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+// compareValues compares two values as numbers if either is a number and the
+// other can be read as one, and as text otherwise.
+func compareValues(locale LocaleFormat, left any, right any) int {
+	_, leftIsNumber := left.(float64)
+	_, rightIsNumber := right.(float64)
+	if leftIsNumber || rightIsNumber {
+		l, lerr := locale.toNumber(left)
+		r, rerr := locale.toNumber(right)
+		if lerr == nil && rerr == nil {
+			switch {
+			case l < r:
+				return -1
+			case l > r:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(locale.toText(left), locale.toText(right))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseTestSource parses source as if it were written between {{ and }} at
+// the start of a line.
+func parseTestSource(source string) (Expr, error) {
+	return parseExpression(Segment{Kind: PlaceholderSegment, Text: source, Line: 1, Column: 1})
+}
+
+func evalSource(t *testing.T, source string, vars map[string]any) (any, error) {
+	t.Helper()
+	expr, err := parseTestSource(source)
+	if err != nil {
+		t.Fatalf("%s: parse error: %v", source, err)
+	}
+	return evalExpr(expr, &exprEnv{Vars: vars})
+}
+
+func TestEvalExpr(t *testing.T) {
+	vars := map[string]any{
+		"name":  "ada",
+		"price": 2.5,
+		"qty":   "3",
+		"paid":  true,
+		"empty": "",
+		"order": map[string]any{"items": []any{"tea", "cake"}},
+	}
+	tests := []struct {
+		source string
+		want   any
+	}{
+		// Arithmetic
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 2 - 3", 5.0},
+		{"12 / 4 / 3", 1.0},
+		{"7 % 4", 3.0},
+		{"-2 * 3", -6.0},
+		{"price * qty", 7.5},
+
+		// ~ joins text and binds more loosely than arithmetic but more
+		// tightly than comparisons.
+		{"1 + 2 ~ 3", "33"},
+		{"'a' ~ 1 + 1", "a2"},
+		{"name ~ '!' == 'ada!'", true},
+		{"'x' ~ price * 2", "x5"},
+
+		// Comparisons
+		{"qty == 3", true},
+		{"qty > 10", false},
+		{"'b' > 'a'", true},
+		{"name != 'ada'", false},
+
+		// and, or and not
+		{"paid and 'PAID' or 'DUE'", "PAID"},
+		{"not paid and 'PAID' or 'DUE'", "DUE"},
+		{"empty or 'none'", "none"},
+		{"empty and 'x'", ""},
+		{"1 == 1 or 1 == 2 and false", true},
+		{"not 1 == 2", true},
+		{"not empty", true},
+		{"false or nil", nil},
+
+		// Members and filters
+		{"order.items[2]", "cake"},
+		{"order.items | length", 2.0},
+		{"(name | upper) ~ '!'", "ADA!"},
+		{"name ~ '!' | upper", "ADA!"},
+		{"name | capitalize | pad:5", "Ada  "},
+		{"missing | default:'n/a'", "n/a"},
+		{"order.items | join:' & '", "tea & cake"},
+		{"3.14159 | round:2", 3.14},
+	}
+
+	for _, tt := range tests {
+		got, err := evalSource(t, tt.source, vars)
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"1 / 0", "line 1, column 3: division by zero"},
+		{"'abc' * 2", `"abc" is not a number`},
+		{"name | upper:1", "upper: expected no arguments"},
+		{"name | default", "default: expected a value to use instead"},
+		{"name | replace:'a'", "replace: expected the text to find and its replacement"},
+		{"name | pad:1:'left':3", "pad: expected a width"},
+		{"name | shout", "unknown filter shout"},
+	}
+
+	for _, tt := range tests {
+		_, err := evalSource(t, tt.source, map[string]any{"name": "ada"})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want it to contain %q", tt.source, err, tt.want)
+		}
+	}
+}
+
+func TestFilterArity(t *testing.T) {
+	for name, filter := range filters {
+		if filter.MinArgs > filter.MaxArgs {
+			t.Errorf("%s: MinArgs %d is more than MaxArgs %d", name, filter.MinArgs, filter.MaxArgs)
+		}
+		if err := filter.checkArgs(filter.MinArgs); err != nil {
+			t.Errorf("%s: %d args: %v", name, filter.MinArgs, err)
+		}
+		if err := filter.checkArgs(filter.MaxArgs + 1); err == nil {
+			t.Errorf("%s: %d args should be too many", name, filter.MaxArgs+1)
+		}
+		if filter.MinArgs > 0 && filter.checkArgs(filter.MinArgs-1) == nil {
+			t.Errorf("%s: %d args should be too few", name, filter.MinArgs-1)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", "line 1, column 1: placeholder is empty"},
+		{"1 +", "line 1, column 4: expected a value but found end of placeholder"},
+		{"(1 + 2", "line 1, column 7: expected ')' but found end of placeholder"},
+		{"a b", "line 1, column 3: unexpected 'b'"},
+		{"x | 1", "line 1, column 5: expected a name but found '1'"},
+		{"a.b(1,", "line 1, column 7: expected a value but found end of placeholder"},
+		{"a # b", "line 1, column 3: unexpected character '#'"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseTestSource(tt.source)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: parse error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+}
+
+func TestEvalExprUsesJobLocale(t *testing.T) {
+	job := &hostJob{Locale: mustFindLocale("de-DE")}
+	env := &exprEnv{Vars: map[string]any{"price": "2,50"}, ctx: withHostJob(context.Background(), job)}
+
+	tests := []struct {
+		source string
+		want   any
+	}{
+		{"price * 2", 5.0},
+		{"1.5 ~ ''", "1,5"},
+		{"1234.5 | number:2", "1.234,50"},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseTestSource(tt.source)
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", tt.source, err)
+		}
+		got, err := evalExpr(expr, env)
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
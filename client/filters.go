@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// exprFilter is a function which can be applied to a value in a placeholder
+// with "| name:arg:arg".
+type exprFilter struct {
+	MinArgs int
+	MaxArgs int
+	Usage   string
+	Apply   func(locale LocaleFormat, input any, args []any) (any, error)
+}
+
+func (f exprFilter) checkArgs(n int) error {
+	if n < f.MinArgs || n > f.MaxArgs {
+		return fmt.Errorf("expected %s", f.Usage)
+	}
+	return nil
+}
+
+var filters = map[string]exprFilter{
+	"upper": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		return strings.ToUpper(locale.toText(input)), nil
+	}},
+	"lower": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		return strings.ToLower(locale.toText(input)), nil
+	}},
+	"trim": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		return strings.TrimSpace(locale.toText(input)), nil
+	}},
+	"capitalize": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		text := locale.toText(input)
+		first, size := utf8.DecodeRuneInString(text)
+		if size == 0 {
+			return text, nil
+		}
+		return string(unicode.ToUpper(first)) + text[size:], nil
+	}},
+	"default": {MinArgs: 1, MaxArgs: 1, Usage: "a value to use instead", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		if locale.toText(input) == "" {
+			return args[0], nil
+		}
+		return input, nil
+	}},
+	"pad": {MinArgs: 1, MaxArgs: 2, Usage: `a width and optionally "left", "right" or "center"`, Apply: filterPad},
+	"truncate": {MinArgs: 1, MaxArgs: 2, Usage: "a length and optionally text to end with", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		length, err := intArg(locale, args[0])
+		if err != nil {
+			return nil, err
+		}
+		runes := []rune(locale.toText(input))
+		if len(runes) <= length {
+			return string(runes), nil
+		}
+		suffix := ""
+		if len(args) > 1 {
+			suffix = locale.toText(args[1])
+		}
+		keep := max(length-utf8.RuneCountInString(suffix), 0)
+		return string(runes[:keep]) + suffix, nil
+	}},
+	"replace": {MinArgs: 2, MaxArgs: 2, Usage: "the text to find and its replacement", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		return strings.ReplaceAll(locale.toText(input), locale.toText(args[0]), locale.toText(args[1])), nil
+	}},
+	"round": {MaxArgs: 1, Usage: "optionally a number of decimal places", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		n, err := locale.toNumber(input)
+		if err != nil {
+			return nil, err
+		}
+		decimals := 0
+		if len(args) > 0 {
+			if decimals, err = intArg(locale, args[0]); err != nil {
+				return nil, err
+			}
+		}
+		scale := math.Pow(10, float64(decimals))
+		return math.Round(n*scale) / scale, nil
+	}},
+	"number": {MaxArgs: 1, Usage: "optionally a number of decimal places", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		n, err := locale.toNumber(input)
+		if err != nil {
+			return nil, err
+		}
+		decimals := -1
+		if len(args) > 0 {
+			if decimals, err = intArg(locale, args[0]); err != nil {
+				return nil, err
+			}
+		}
+		return locale.FormatNumber(n, decimals), nil
+	}},
+	"currency": {MaxArgs: 1, Usage: "optionally a currency code", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		n, err := locale.toNumber(input)
+		if err != nil {
+			return nil, err
+		}
+		code := ""
+		if len(args) > 0 {
+			code = locale.toText(args[0])
+		}
+		return locale.FormatCurrency(n, code), nil
+	}},
+	"length": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		switch v := input.(type) {
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		}
+		return float64(utf8.RuneCountInString(locale.toText(input))), nil
+	}},
+	"join": {MaxArgs: 1, Usage: "optionally text to put between the items", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		list, ok := input.([]any)
+		if !ok {
+			return locale.toText(input), nil
+		}
+		sep := ", "
+		if len(args) > 0 {
+			sep = locale.toText(args[0])
+		}
+		texts := make([]string, len(list))
+		for i, item := range list {
+			texts[i] = locale.toText(item)
+		}
+		return strings.Join(texts, sep), nil
+	}},
+	"first": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		if list, ok := input.([]any); ok && len(list) > 0 {
+			return list[0], nil
+		}
+		return nil, nil
+	}},
+	"last": {Usage: "no arguments", Apply: func(locale LocaleFormat, input any, args []any) (any, error) {
+		if list, ok := input.([]any); ok && len(list) > 0 {
+			return list[len(list)-1], nil
+		}
+		return nil, nil
+	}},
+}
+
+func filterPad(locale LocaleFormat, input any, args []any) (any, error) {
+	width, err := intArg(locale, args[0])
+	if err != nil {
+		return nil, err
+	}
+	align := "left"
+	if len(args) > 1 {
+		align = locale.toText(args[1])
+	}
+
+	text := locale.toText(input)
+	gap := width - utf8.RuneCountInString(text)
+	if gap <= 0 {
+		return text, nil
+	}
+	switch align {
+	case "left":
+		return text + strings.Repeat(" ", gap), nil
+	case "right":
+		return strings.Repeat(" ", gap) + text, nil
+	case "center":
+		return strings.Repeat(" ", gap/2) + text + strings.Repeat(" ", gap-gap/2), nil
+	}
+	return nil, fmt.Errorf("unknown alignment %q", align)
+}
+
+func intArg(locale LocaleFormat, v any) (int, error) {
+	n, err := locale.toNumber(v)
+	if err != nil {
+		return 0, err
+	}
+	if n != math.Trunc(n) || n < 0 {
+		return 0, fmt.Errorf("%s is not a whole number", locale.toText(v))
+	}
+	return int(n), nil
+}
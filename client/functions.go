@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// pluginSignature describes how to call a plugin function, with optional
+// arguments in brackets, e.g. `Shop.price(item: string, [size: string = "S"]) → number`.
+func pluginSignature(plugin string, f FunctionInfo) string {
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		param := p.Type
+		if p.Name != "" {
+			param = p.Name + ": " + p.Type
+		}
+		if p.Default != nil {
+			param += " = " + enumText([]any{p.Default})
+		}
+		if p.optional() {
+			param = "[" + param + "]"
+		}
+		params[i] = param
+	}
+	sig := fmt.Sprintf("%s.%s(%s)", plugin, f.Name, strings.Join(params, ", "))
+	if len(f.Returns) > 0 {
+		returns := make([]string, len(f.Returns))
+		for i, r := range f.Returns {
+			returns[i] = r.Type
+		}
+		sig += " → " + strings.Join(returns, ", ")
+	}
+	return sig
+}
+
+// pluginFunctionDoc is a function's description followed by a line for each
+// argument and return value which has one.
+func pluginFunctionDoc(f FunctionInfo) string {
+	var lines []string
+	if f.Description != "" {
+		lines = append(lines, f.Description)
+	}
+	for i, p := range f.Params {
+		var notes []string
+		if p.Description != "" {
+			notes = append(notes, p.Description)
+		}
+		if len(p.Enum) > 0 {
+			notes = append(notes, "one of "+enumText(p.Enum))
+		}
+		if len(notes) > 0 {
+			name := p.Name
+			if name == "" {
+				name = fmt.Sprintf("Argument %d", i+1)
+			}
+			lines = append(lines, "• "+name+": "+strings.Join(notes, "; "))
+		}
+	}
+	for i, r := range f.Returns {
+		switch {
+		case r.Description == "":
+		case len(f.Returns) == 1:
+			lines = append(lines, "• Returns "+r.Description)
+		default:
+			lines = append(lines, fmt.Sprintf("• Return value %d: %s", i+1, r.Description))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func functionRow(signature string, description string) fyne.CanvasObject {
+	sigLabel := widget.NewLabelWithStyle(signature, fyne.TextAlignLeading, fyne.TextStyle{Monospace: true})
+	if description == "" {
+		return sigLabel
+	}
+	descLabel := widget.NewLabel(description)
+	descLabel.Wrapping = fyne.TextWrapWord
+	return container.NewVBox(sigLabel, descLabel)
+}
+
+// showFunctionsDialog lists everything which can be used in a placeholder:
+// the built-in functions, filters, and the functions of each loaded plugin.
+func showFunctionsDialog(w fyne.Window) {
+	list := container.NewVBox()
+
+	list.Add(MakeHeaderLabel("Built in (" + stdNamespace + ")"))
+	for _, name := range slices.Sorted(maps.Keys(stdFunctions)) {
+		f := stdFunctions[name]
+		list.Add(functionRow(f.signature(name)+" → "+f.Returns, f.Description))
+	}
+
+	list.Add(MakeHeaderLabel("Filters"))
+	for _, name := range slices.Sorted(maps.Keys(filters)) {
+		list.Add(functionRow("| "+name, filters[name].Usage))
+	}
+
+	for _, pluginName := range slices.Sorted(maps.Keys(manifests)) {
+		manifest := manifests[pluginName]
+		list.Add(MakeHeaderLabel(fmt.Sprintf("%s %s", manifest.PluginName, manifest.Version)))
+		for _, f := range manifest.Functions {
+			list.Add(functionRow(pluginSignature(manifest.PluginName, f), pluginFunctionDoc(f)))
+		}
+	}
+
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(500, 400))
+	dialog.ShowCustom("Functions", "Close", scroll, w)
+}
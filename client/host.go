@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// appVersion is set when building a release, with
+// -ldflags "-X main.appVersion=1.2.0".
+var appVersion = "dev"
+
+// hostJob is a receipt being filled in, for plugins to read through the
+// receiptify module. It is carried in the context passed to RunPlugin.
+type hostJob struct {
+	ID       string
+	Template string
+	Printer  string
+	Copies   int
+	Preview  bool
+	// Locale is the locale the receipt is written in.
+	Locale LocaleFormat
+	// Variables is filled in as the template's variables are worked out.
+	Variables map[string]any
+}
+
+type hostJobKey struct{}
+
+func newHostJob(template, printer string, copies int, preview bool) *hostJob {
+	return &hostJob{
+		ID:       fmt.Sprintf("%s-%04x", time.Now().Format("20060102-150405"), rand.IntN(0x10000)),
+		Template: template,
+		Printer:  printer,
+		Copies:   copies,
+		Preview:  preview,
+	}
+}
+
+func withHostJob(ctx context.Context, job *hostJob) context.Context {
+	return context.WithValue(ctx, hostJobKey{}, job)
+}
+
+// hostJobFrom finds the receipt being filled in, or nil if the plugin isn't
+// being called for one, such as while its main.lua runs.
+func hostJobFrom(ctx context.Context) *hostJob {
+	if ctx == nil {
+		return nil
+	}
+	job, _ := ctx.Value(hostJobKey{}).(*hostJob)
+	return job
+}
+
+// previewing says whether ctx is for a receipt being expanded for the preview
+// rather than for printing. Plugins must not change anything while it is.
+func previewing(ctx context.Context) bool {
+	job := hostJobFrom(ctx)
+	return job != nil && job.Preview
+}
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// registerHostModule exposes information about the host application to a
+// plugin as a global receiptify table, which can also be required.
+func registerHostModule(L *lua.LState, manifest *PluginManifest, dataPath string) {
+	module := L.NewTable()
+
+	L.SetFuncs(module, map[string]lua.LGFunction{
+		"preview": func(L *lua.LState) int {
+			L.Push(lua.LBool(previewing(L.Context())))
+			return 1
+		},
+		"nextCounter": func(L *lua.LState) int {
+			value, err := NextCounter(L.CheckString(1), previewing(L.Context()))
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(lua.LString(value))
+			return 1
+		},
+		"peekCounter": func(L *lua.LState) int {
+			value, err := PeekCounter(L.CheckString(1))
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(lua.LString(value))
+			return 1
+		},
+		"createCounter": func(L *lua.LState) int {
+			created, err := CreateCounter(L.CheckString(1), L.OptInt(2, 0))
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(lua.LBool(created))
+			return 1
+		},
+		"app": func(L *lua.LState) int {
+			L.Push(goToLua(L, map[string]any{
+				"name":          "Receiptify",
+				"version":       appVersion,
+				"schemaVersion": float64(CurrentSchemaVersion),
+				"os":            runtime.GOOS,
+			}))
+			return 1
+		},
+		"job": func(L *lua.LState) int {
+			job := hostJobFrom(L.Context())
+			if job == nil {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(goToLua(L, map[string]any{
+				"id":       job.ID,
+				"template": job.Template,
+				"preview":  job.Preview,
+				"copies":   float64(job.Copies),
+			}))
+			return 1
+		},
+		"variable": func(L *lua.LState) int {
+			name := L.CheckString(1)
+			if job := hostJobFrom(L.Context()); job != nil {
+				if value, ok := job.Variables[name]; ok {
+					L.Push(goToLua(L, value))
+					return 1
+				}
+			}
+			L.Push(lua.LNil)
+			return 1
+		},
+		"variables": func(L *lua.LState) int {
+			values := map[string]any{}
+			if job := hostJobFrom(L.Context()); job != nil {
+				for name, value := range job.Variables {
+					values[name] = value
+				}
+			}
+			L.Push(goToLua(L, values))
+			return 1
+		},
+		"printer": func(L *lua.LState) int {
+			name := ""
+			if job := hostJobFrom(L.Context()); job != nil {
+				name = job.Printer
+			}
+			if _, ok := findPrinter(name); !ok {
+				name = ""
+			}
+			L.Push(goToLua(L, map[string]any{
+				"name":  name,
+				"url":   printerURL(name),
+				"width": float64(printableWidth),
+			}))
+			return 1
+		},
+	})
+
+	store := L.NewTable()
+	L.SetFuncs(store, map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			values, err := loadPluginStore(dataPath)
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			if value, ok := values[key]; ok {
+				L.Push(goToLua(L, value))
+			} else {
+				L.Push(L.Get(2))
+			}
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			value, err := luaToGo(L.CheckAny(2), 0)
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			if previewing(L.Context()) {
+				return 0
+			}
+			err = withPluginStore(dataPath, func(values map[string]any) (bool, error) {
+				if value == nil {
+					delete(values, key)
+				} else {
+					values[key] = value
+				}
+				return true, nil
+			})
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			return 0
+		},
+		"delete": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			if previewing(L.Context()) {
+				return 0
+			}
+			err := withPluginStore(dataPath, func(values map[string]any) (bool, error) {
+				_, ok := values[key]
+				delete(values, key)
+				return ok, nil
+			})
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			return 0
+		},
+		"keys": func(L *lua.LState) int {
+			values, err := loadPluginStore(dataPath)
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			keys := make([]string, 0, len(values))
+			for key := range values {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			table := L.NewTable()
+			for _, key := range keys {
+				table.Append(lua.LString(key))
+			}
+			L.Push(table)
+			return 1
+		},
+	})
+	module.RawSetString("store", store)
+
+	logTable := L.NewTable()
+	for name, level := range logLevels {
+		logTable.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+			message := L.CheckString(1)
+			attrs := []any{"plugin", manifest.PluginName}
+			if job := hostJobFrom(L.Context()); job != nil {
+				attrs = append(attrs, "job", job.ID)
+			}
+			if fields := L.OptTable(2, nil); fields != nil {
+				converted, err := luaToGo(fields, 0)
+				if err != nil {
+					L.RaiseError("%v", err)
+				}
+				if m, ok := converted.(map[string]any); ok {
+					keys := make([]string, 0, len(m))
+					for key := range m {
+						keys = append(keys, key)
+					}
+					slices.Sort(keys)
+					for _, key := range keys {
+						attrs = append(attrs, key, m[key])
+					}
+				}
+			}
+			slog.Log(context.Background(), level, message, attrs...)
+			return 0
+		}))
+	}
+	module.RawSetString("log", logTable)
+
+	L.SetGlobal("receiptify", module)
+	if loaded, ok := L.GetField(L.GetGlobal("package"), "loaded").(*lua.LTable); ok {
+		loaded.RawSetString("receiptify", module)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newComponentID generates a random ID for a component. IDs are kept when
+// components are reordered, copied between templates, imported or exported,
+// so they can be used to refer to a component regardless of its name.
+func newComponentID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ensureComponentIDs gives every component in a layout an ID, replacing any
+// that duplicate an earlier component's.
+func ensureComponentIDs(layout []Component) []Component {
+	seen := make(map[string]bool, len(layout))
+	for i := range layout {
+		if layout[i].ID == "" || seen[layout[i].ID] {
+			layout[i].ID = newComponentID()
+		}
+		seen[layout[i].ID] = true
+	}
+	return layout
+}
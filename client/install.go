@@ -0,0 +1,482 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// pluginPackage is a plugin read from a zip file or folder, ready to install.
+// Files are keyed by their slash separated path inside the plugin.
+type pluginPackage struct {
+	Manifest PluginManifest
+	Files    map[string][]byte
+}
+
+// readPluginPackage reads and checks a plugin from a zip file or a folder.
+// The plugin can be at the top of the zip, or in a single folder inside it.
+func readPluginPackage(source string) (*pluginPackage, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var fsys fs.FS
+	if info.IsDir() {
+		fsys = os.DirFS(source)
+	} else {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a zip file or folder", source)
+		}
+		fsys = zr
+	}
+
+	root := "."
+	if _, err := fs.Stat(fsys, "manifest.json"); err != nil {
+		entries, _ := fs.ReadDir(fsys, ".")
+		if len(entries) != 1 || !entries[0].IsDir() {
+			return nil, fmt.Errorf("no manifest.json found")
+		}
+		root = entries[0].Name()
+	}
+
+	pkg := &pluginPackage{Files: map[string][]byte{}}
+	err = fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+		if d.IsDir() {
+			// The data folder belongs to whoever installed the plugin.
+			if rel == "data" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		pkg.Files[rel] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(pkg.Files["manifest.json"], &pkg.Manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	if err := checkPluginPackage(pkg); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+func checkPluginPackage(pkg *pluginPackage) error {
+	m := &pkg.Manifest
+	if !variableNameRegexp.MatchString(m.PluginName) {
+		return fmt.Errorf("plugin name %q must start with a letter or underscore and contain only letters, digits and underscores", m.PluginName)
+	}
+	if m.PluginName == stdNamespace {
+		return fmt.Errorf("the name %s is reserved for built-in functions", stdNamespace)
+	}
+	if m.Version == "" {
+		return fmt.Errorf("the manifest has no version")
+	}
+	if err := validateConfigFields(m.Config); err != nil {
+		return fmt.Errorf("error in manifest: %v", err)
+	}
+	if err := validateFunctions(m.Functions); err != nil {
+		return fmt.Errorf("error in manifest: %v", err)
+	}
+	if _, ok := pkg.Files["main.lua"]; !ok {
+		return fmt.Errorf("no main.lua found")
+	}
+	for name, data := range pkg.Files {
+		if path.Ext(name) != ".lua" {
+			continue
+		}
+		if _, err := parse.Parse(bytes.NewReader(data), name); err != nil {
+			return fmt.Errorf("%s: %v", name, strings.TrimSpace(err.Error()))
+		}
+	}
+	return nil
+}
+
+// compareVersions compares dotted version numbers such as "1.10.2", number
+// by number, so 1.10 is newer than 1.9. Letters after a number, as in
+// "1.2a", are compared as text once the numbers are equal. A pre-release such
+// as "1.0.0-beta.2" comes before the release itself, and build metadata after
+// a "+" is ignored.
+func compareVersions(a, b string) int {
+	aCore, aPre := splitVersion(a)
+	bCore, bPre := splitVersion(b)
+
+	as := strings.Split(aCore, ".")
+	bs := strings.Split(bCore, ".")
+	for i := range max(len(as), len(bs)) {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xRest := splitVersionPart(x)
+		yn, yRest := splitVersionPart(y)
+		if c := cmp.Compare(xn, yn); c != 0 {
+			return c
+		}
+		if c := strings.Compare(xRest, yRest); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return comparePreReleases(aPre, bPre)
+}
+
+// splitVersion splits a version into its dotted numbers and its pre-release,
+// dropping any leading "v" and build metadata.
+func splitVersion(version string) (string, string) {
+	version = strings.TrimPrefix(version, "v")
+	version, _, _ = strings.Cut(version, "+")
+	core, pre, _ := strings.Cut(version, "-")
+	return core, pre
+}
+
+// splitVersionPart splits a part such as "10a" into its number and the text
+// after it. A part with no number counts as 0.
+func splitVersionPart(part string) (int, string) {
+	digits := len(part) - len(strings.TrimLeft(part, "0123456789"))
+	n, _ := strconv.Atoi(part[:digits])
+	return n, part[digits:]
+}
+
+// comparePreReleases compares pre-releases such as "beta.2" and "rc.1" the
+// way semantic versioning does: numeric identifiers as numbers and before any
+// text, and a shorter list of identifiers first when the rest are equal.
+func comparePreReleases(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := range min(len(as), len(bs)) {
+		xn, xErr := strconv.Atoi(as[i])
+		yn, yErr := strconv.Atoi(bs[i])
+		var c int
+		switch {
+		case xErr == nil && yErr == nil:
+			c = cmp.Compare(xn, yn)
+		case xErr == nil:
+			c = -1
+		case yErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
+
+// installedPlugin finds the folder in the plugin path holding the plugin with
+// a name, and its manifest.
+func installedPlugin(name string) (string, *PluginManifest) {
+	folders, err := os.ReadDir(settings.PluginPath)
+	if err != nil {
+		return "", nil
+	}
+	for _, folder := range folders {
+		if !folder.IsDir() || strings.HasPrefix(folder.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(settings.PluginPath, folder.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+		if err != nil {
+			continue
+		}
+		var manifest PluginManifest
+		if json.Unmarshal(data, &manifest) == nil && manifest.PluginName == name {
+			return dir, &manifest
+		}
+	}
+	return "", nil
+}
+
+// installPlugin writes a plugin into the plugin path, replacing any installed
+// copy but keeping its data folder. It returns the plugin's folder.
+func installPlugin(pkg *pluginPackage) (string, error) {
+	if settings.PluginPath == "" {
+		return "", fmt.Errorf("plugin path not set")
+	}
+	if err := os.MkdirAll(settings.PluginPath, 0755); err != nil {
+		return "", err
+	}
+
+	target, _ := installedPlugin(pkg.Manifest.PluginName)
+	if target == "" {
+		target = filepath.Join(settings.PluginPath, strings.ToLower(pkg.Manifest.PluginName))
+		// A folder left with only its data by an uninstall is reused.
+		if _, err := os.Stat(filepath.Join(target, "manifest.json")); err == nil {
+			return "", fmt.Errorf("%s already exists and holds a different plugin", target)
+		}
+	}
+
+	// Write the new copy next to the old one first, so a failure part way
+	// through leaves the installed copy alone. tmp is only removed if it
+	// doesn't hold the plugin's data.
+	tmp, err := os.MkdirTemp(settings.PluginPath, ".install-")
+	if err != nil {
+		return "", err
+	}
+	tmpData := filepath.Join(tmp, "data")
+	defer func() {
+		if _, err := os.Stat(tmpData); errors.Is(err, os.ErrNotExist) {
+			os.RemoveAll(tmp)
+		}
+	}()
+	for name, data := range pkg.Files {
+		dest := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(dest, data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	// Move the old copy aside and its data into the new copy, then move the
+	// new copy into place. Until that has worked everything is put back, and
+	// the old copy is only deleted once its data is safely in the new one.
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(tmp, target); err != nil {
+			return "", err
+		}
+		return target, nil
+	}
+	old := filepath.Join(settings.PluginPath, ".old-"+strings.TrimPrefix(filepath.Base(tmp), ".install-"))
+	if err := os.Rename(target, old); err != nil {
+		return "", err
+	}
+	oldData := filepath.Join(old, "data")
+	movedData := ""
+	if _, err := os.Stat(oldData); err == nil {
+		if err := os.Rename(oldData, tmpData); err != nil {
+			return "", restoreInstalledPlugin(fmt.Errorf("could not keep the plugin's data: %v", err), old, target, "", oldData)
+		}
+		movedData = tmpData
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return "", restoreInstalledPlugin(err, old, target, movedData, oldData)
+	}
+	if err := os.RemoveAll(old); err != nil {
+		log.Printf("Could not remove the old copy of %s from %s: %v", pkg.Manifest.PluginName, old, err)
+	}
+	return target, nil
+}
+
+// restoreInstalledPlugin puts a plugin moved aside by installPlugin back, with
+// its data if that was moved from oldData to data, and adds anything which
+// couldn't be put back to err. data is empty if the data wasn't moved.
+func restoreInstalledPlugin(err error, old string, target string, data string, oldData string) error {
+	if data != "" {
+		if restoreErr := os.Rename(data, oldData); restoreErr != nil {
+			return fmt.Errorf("%v, and the plugin's data couldn't be put back, so it was left in %s", err, data)
+		}
+	}
+	if restoreErr := os.Rename(old, target); restoreErr != nil {
+		return fmt.Errorf("%v, and the installed copy couldn't be put back, so it was left in %s", err, old)
+	}
+	return err
+}
+
+// uninstallPlugin removes a plugin from the plugin path. If keepData is set,
+// its data folder is left behind so installing it again picks up where it
+// left off.
+func uninstallPlugin(dir string, keepData bool) error {
+	if !keepData {
+		return os.RemoveAll(dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == "data" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installAction describes what installing a plugin will do, such as
+// "Upgrade Greet from 1.0.0 to 1.1.0".
+func installAction(pkg *pluginPackage) (string, int) {
+	m := &pkg.Manifest
+	_, installed := installedPlugin(m.PluginName)
+	if installed == nil {
+		return fmt.Sprintf("Install %s %s", m.PluginName, m.Version), 1
+	}
+	cmp := compareVersions(m.Version, installed.Version)
+	switch {
+	case cmp > 0:
+		return fmt.Sprintf("Upgrade %s from %s to %s", m.PluginName, installed.Version, m.Version), cmp
+	case cmp < 0:
+		return fmt.Sprintf("Downgrade %s from %s to %s", m.PluginName, installed.Version, m.Version), cmp
+	}
+	return fmt.Sprintf("Reinstall %s %s", m.PluginName, m.Version), cmp
+}
+
+// installPluginCLI installs a plugin from the command line. Installing an
+// older version over a newer one needs force.
+func installPluginCLI(source string, force bool) error {
+	pkg, err := readPluginPackage(source)
+	if err != nil {
+		return err
+	}
+	action, cmp := installAction(pkg)
+	if cmp < 0 && !force {
+		return fmt.Errorf("%s? Use -force to install the older version", action)
+	}
+	dir, err := installPlugin(pkg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: done, in %s\n", action, dir)
+	return nil
+}
+
+func uninstallPluginCLI(name string, deleteData bool) error {
+	dir, manifest := installedPlugin(name)
+	if manifest == nil {
+		return fmt.Errorf("plugin %s is not installed in %s", name, settings.PluginPath)
+	}
+	if err := uninstallPlugin(dir, !deleteData); err != nil {
+		return err
+	}
+	fmt.Printf("Uninstalled %s %s from %s\n", manifest.PluginName, manifest.Version, dir)
+	return nil
+}
+
+// showInstallPluginDialog lets the user pick a zip file or folder to install
+// a plugin from.
+func showInstallPluginDialog(onDone func(), w fyne.Window) {
+	var choose *dialog.CustomDialog
+
+	install := func(source string) {
+		pkg, err := readPluginPackage(source)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("could not install plugin: %v", err), w)
+			return
+		}
+		action, cmp := installAction(pkg)
+		message := action + "?"
+		if cmp < 0 {
+			message = action + "? This is an older version than the one installed."
+		}
+		dialog.ShowConfirm("Install Plugin", message, func(confirm bool) {
+			if !confirm {
+				return
+			}
+			dir, err := installPlugin(pkg)
+			if err == nil {
+				err = reloadPluginFromDisk(dir)
+			}
+			if err != nil {
+				dialog.ShowError(err, w)
+			}
+			askPluginPermissions(w)
+			onDone()
+		}, w)
+	}
+
+	zipBtn := widget.NewButton("Install from Zip File", func() {
+		choose.Hide()
+		fd := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			reader.Close()
+			install(reader.URI().Path())
+		}, w)
+		fd.SetFilter(storage.NewExtensionFileFilter([]string{".zip"}))
+		fd.Show()
+	})
+	folderBtn := widget.NewButton("Install from Folder", func() {
+		choose.Hide()
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err != nil || uri == nil {
+				return
+			}
+			install(uri.Path())
+		}, w)
+	})
+
+	choose = dialog.NewCustom("Install Plugin", "Cancel", container.NewVBox(
+		widget.NewLabel("A plugin is a folder, or a zip file of one, with a manifest.json and main.lua."),
+		zipBtn,
+		folderBtn,
+	), w)
+	choose.Show()
+}
+
+func showUninstallPluginDialog(entry *pluginEntry, onDone func(), w fyne.Window) {
+	var confirm *dialog.CustomDialog
+
+	uninstall := func(keepData bool) {
+		confirm.Hide()
+		err := uninstallPlugin(entry.Path, keepData)
+		if err == nil {
+			err = reloadPluginFromDisk(entry.Path)
+		}
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
+		onDone()
+	}
+
+	keepBtn := widget.NewButton("Uninstall and Keep Data", func() { uninstall(true) })
+	deleteBtn := widget.NewButton("Uninstall and Delete Data", func() { uninstall(false) })
+	deleteBtn.Importance = widget.DangerImportance
+
+	confirm = dialog.NewCustom("Uninstall "+entry.name(), "Cancel", container.NewVBox(
+		widget.NewLabel("Keep the plugin's data, such as saved numbers, in case you install it again?"),
+		keepBtn,
+		deleteBtn,
+	), w)
+	confirm.Show()
+}
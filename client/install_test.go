@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.10", "1.9", 1},
+		{"1.9", "1.10", -1},
+		{"1.2.3", "1.2.10", -1},
+		{"2", "1.99.99", 1},
+		{"1.0", "1", 0},
+		{"1", "1.0.1", -1},
+		{"v1", "1", 0},
+		{"v1.2", "v1.10", -1},
+		{"1.2a", "1.2b", -1},
+		{"1.2b", "1.2a", 1},
+		{"1.2", "1.2a", -1},
+		{"1.10a", "1.9", 1},
+		{"1.9a", "1.10", -1},
+		{"1.0.0-beta", "1.0.0", -1},
+		{"1.0.0", "1.0.0-beta", 1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.10", -1},
+		{"1.0.0-beta", "1.0.0-beta.1", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-rc.1", "0.9.9", 1},
+		{"1.0.0+build.5", "1.0.0", 0},
+		{"v2.0.0-rc.1", "2.0.0-rc.1", 0},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInstallPluginKeepsData(t *testing.T) {
+	settings.PluginPath = t.TempDir()
+	t.Cleanup(func() { settings.PluginPath = "" })
+
+	target := filepath.Join(settings.PluginPath, "greet")
+	writeTestFiles(t, target, map[string]string{
+		"manifest.json":  `{"name": "Greet", "version": "1.0.0", "functions": []}`,
+		"main.lua":       `Greet = {}`,
+		"old.lua":        `return {}`,
+		"data/mine.txt":  "mine",
+		"data/sub/x.txt": "x",
+	})
+
+	pkg := &pluginPackage{
+		Manifest: PluginManifest{PluginName: "Greet", Version: "1.1.0"},
+		Files: map[string][]byte{
+			"manifest.json": []byte(`{"name": "Greet", "version": "1.1.0", "functions": []}`),
+			"main.lua":      []byte(`Greet = {}`),
+		},
+	}
+	dir, err := installPlugin(pkg)
+	if err != nil {
+		t.Fatalf("installPlugin error: %v", err)
+	}
+	if dir != target {
+		t.Errorf("installed to %s, want %s", dir, target)
+	}
+	if got := readTestFile(t, filepath.Join(target, "data", "mine.txt")); got != "mine" {
+		t.Errorf("data/mine.txt = %q, want mine", got)
+	}
+	if got := readTestFile(t, filepath.Join(target, "data", "sub", "x.txt")); got != "x" {
+		t.Errorf("data/sub/x.txt = %q, want x", got)
+	}
+	if _, err := os.Stat(filepath.Join(target, "old.lua")); !os.IsNotExist(err) {
+		t.Errorf("old.lua from the old version is still there")
+	}
+	_, installed := installedPlugin("Greet")
+	if installed == nil || installed.Version != "1.1.0" {
+		t.Errorf("installed plugin = %+v, want version 1.1.0", installed)
+	}
+
+	// Nothing is left behind in the plugin path but the plugin.
+	entries, err := os.ReadDir(settings.PluginPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("plugin path holds %v, want only greet", names)
+	}
+}
+
+func TestRestoreInstalledPlugin(t *testing.T) {
+	root := t.TempDir()
+	old := filepath.Join(root, ".old-1")
+	target := filepath.Join(root, "greet")
+	data := filepath.Join(root, ".install-1", "data")
+	writeTestFiles(t, old, map[string]string{"main.lua": "old"})
+	writeTestFiles(t, data, map[string]string{"mine.txt": "mine"})
+
+	err := restoreInstalledPlugin(os.ErrPermission, old, target, data, filepath.Join(old, "data"))
+	if err != os.ErrPermission {
+		t.Errorf("error = %v, want the original error", err)
+	}
+	if got := readTestFile(t, filepath.Join(target, "main.lua")); got != "old" {
+		t.Errorf("main.lua = %q, want the old copy back", got)
+	}
+	if got := readTestFile(t, filepath.Join(target, "data", "mine.txt")); got != "mine" {
+		t.Errorf("data/mine.txt = %q, want the data back", got)
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"slices"
+	"strings"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
@@ -8,14 +12,95 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+const (
+	sortByName     = "Name"
+	sortByModified = "Last modified"
+	sortByCreated  = "Created"
+	sortByAuthor   = "Author"
+)
+
+var librarySortOrder = sortByName
+
+// sortedLibraryIndexes returns the positions of the library templates in
+// display order, leaving the library itself untouched. Dates sort newest
+// first, and templates which tie are sorted by name.
+func sortedLibraryIndexes(order string) []int {
+	indexes := make([]int, len(settings.Library))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		ta, tb := settings.Library[a], settings.Library[b]
+		var c int
+		switch order {
+		case sortByModified:
+			c = tb.Modified.Compare(ta.Modified)
+		case sortByCreated:
+			c = tb.Created.Compare(ta.Created)
+		case sortByAuthor:
+			c = strings.Compare(strings.ToLower(ta.Author), strings.ToLower(tb.Author))
+		}
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(strings.ToLower(ta.Name), strings.ToLower(tb.Name))
+	})
+	return indexes
+}
+
+func templateSummary(tmpl Template) string {
+	var parts []string
+	if tmpl.Description != "" {
+		parts = append(parts, tmpl.Description)
+	}
+	if len(tmpl.Tags) > 0 {
+		parts = append(parts, "Tags: "+strings.Join(tmpl.Tags, ", "))
+	}
+	if tmpl.Author != "" {
+		parts = append(parts, "Author: "+tmpl.Author)
+	}
+	if !tmpl.Modified.IsZero() {
+		modified := "Modified: " + formatTimestamp(tmpl.Modified)
+		if tmpl.ModifiedBy != "" {
+			modified += " by " + tmpl.ModifiedBy
+		}
+		parts = append(parts, modified)
+	}
+	return strings.Join(parts, "  ·  ")
+}
+
+// cloneTemplate copies a template so the copy can be changed without changing
+// the original.
+func cloneTemplate(tmpl Template) Template {
+	tmpl.Tags = slices.Clone(tmpl.Tags)
+	tmpl.Layout = cloneLayout(tmpl.Layout)
+	tmpl.Variants = slices.Clone(tmpl.Variants)
+	for i := range tmpl.Variants {
+		tmpl.Variants[i].Layout = cloneLayout(tmpl.Variants[i].Layout)
+	}
+	tmpl.Styles = slices.Clone(tmpl.Styles)
+	tmpl.Variables = slices.Clone(tmpl.Variables)
+	return tmpl
+}
+
+func cloneLayout(layout []Component) []Component {
+	layout = slices.Clone(layout)
+	for i := range layout {
+		layout[i].Overrides = slices.Clone(layout[i].Overrides)
+	}
+	return layout
+}
+
 func LibraryUI(w fyne.Window) fyne.CanvasObject {
 	listContainer := container.NewVBox()
 
 	var refreshList func()
 	refreshList = func() {
 		listContainer.Objects = nil
-		for i, tmpl := range settings.Library {
+		for _, i := range sortedLibraryIndexes(librarySortOrder) {
 			idx := i
+			tmpl := settings.Library[i]
 			nameBtn := widget.NewButton(tmpl.Name, func() {
 				var howDoWeOpen *dialog.CustomDialog
 				howDoWeOpen = dialog.NewCustom("Open Template", "Cancel",
@@ -47,16 +132,40 @@ func LibraryUI(w fyne.Window) fyne.CanvasObject {
 				}, w)
 			})
 
-			row := container.NewBorder(nil, nil, nil, deleteBtn, nameBtn)
+			duplicateBtn := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), func() {
+				duplicate := cloneTemplate(tmpl)
+				duplicate.Name = tmpl.Name + " (copy)"
+				duplicate.Created = time.Time{}
+				stampTemplate(&duplicate.TemplateMetadata)
+				settings.Library = append(settings.Library, duplicate)
+				SaveSettings(false, w)
+				refreshList()
+			})
+
+			row := container.NewBorder(nil, nil, nil, container.NewHBox(duplicateBtn, deleteBtn), nameBtn)
 			listContainer.Add(row)
+
+			if summary := templateSummary(tmpl); summary != "" {
+				summaryLabel := widget.NewLabel(summary)
+				summaryLabel.Wrapping = fyne.TextWrapWord
+				summaryLabel.Importance = widget.LowImportance
+				listContainer.Add(summaryLabel)
+			}
 		}
 		listContainer.Refresh()
 	}
 
+	sortSelect := widget.NewSelect([]string{sortByName, sortByModified, sortByCreated, sortByAuthor}, func(s string) {
+		librarySortOrder = s
+		refreshList()
+	})
+	sortSelect.Selected = librarySortOrder
+
 	refreshList()
 
 	return container.NewVBox(
 		MakeHeaderLabel("Template Library"),
+		widget.NewForm(widget.NewFormItem("Sort by", sortSelect)),
 		listContainer,
 	)
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortedLibraryIndexes(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 12, 0, 0, 0, time.UTC) }
+	settings.Library = []Template{
+		{Name: "delta", TemplateMetadata: TemplateMetadata{Author: "bo", Created: day(1), Modified: day(5)}},
+		{Name: "Alpha", TemplateMetadata: TemplateMetadata{Author: "Cy", Created: day(2), Modified: day(5)}},
+		{Name: "charlie", TemplateMetadata: TemplateMetadata{Author: "Bo", Created: day(2), Modified: day(3)}},
+		{Name: "Bravo", TemplateMetadata: TemplateMetadata{Created: day(1), Modified: day(5)}},
+	}
+	t.Cleanup(func() { settings.Library = nil })
+
+	tests := []struct {
+		order string
+		want  []int
+	}{
+		{sortByName, []int{1, 3, 2, 0}},
+		{sortByModified, []int{1, 3, 0, 2}},
+		{sortByCreated, []int{1, 2, 3, 0}},
+		{sortByAuthor, []int{3, 2, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		if got := sortedLibraryIndexes(tt.order); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: order = %v, want %v", tt.order, got, tt.want)
+		}
+	}
+}
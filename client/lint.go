@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a single problem found in a template. Index is the position
+// of the offending component in the layout, or -1 if the problem is with the
+// template as a whole. ComponentID identifies the same component even if the
+// layout has since been reordered.
+type Diagnostic struct {
+	Index       int
+	ComponentID string
+	Severity    Severity
+	Message     string
+}
+
+func (d Diagnostic) String() string {
+	if d.Index < 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: component %d: %s", d.Severity, d.Index+1, d.Message)
+}
+
+var alignments = []string{"", "left", "center", "right"}
+
+// LintTemplate checks a template for problems which would make printing fail
+// or come out wrong.
+func LintTemplate(tmpl Template) []Diagnostic {
+	var diags []Diagnostic
+	if tmpl.Printer != "" {
+		if _, ok := findPrinter(tmpl.Printer); !ok {
+			diags = append(diags, Diagnostic{
+				Index:    -1,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("printer profile %q does not exist, the default print server will be used", tmpl.Printer),
+			})
+		}
+	}
+	var known []string
+	for _, v := range tmpl.Variables {
+		report := func(severity Severity, format string, args ...any) {
+			diags = append(diags, Diagnostic{
+				Index:    -1,
+				Severity: severity,
+				Message:  fmt.Sprintf("variable %q: ", v.Name) + fmt.Sprintf(format, args...),
+			})
+		}
+		if err := validVariableName(v.Name); err != nil {
+			report(SeverityError, "%v", err)
+		}
+		if v.Expression != "" {
+			lintSource(v.Expression, known, report)
+		}
+		known = append(known, v.Name)
+	}
+	for i, c := range tmpl.Layout {
+		diags = append(diags, lintComponent(i, c, tmpl.Styles, known)...)
+	}
+	return diags
+}
+
+func lintComponent(index int, c Component, styles []NamedStyle, variables []string) []Diagnostic {
+	var diags []Diagnostic
+	report := func(severity Severity, format string, args ...any) {
+		diags = append(diags, Diagnostic{
+			Index:       index,
+			ComponentID: c.ID,
+			Severity:    severity,
+			Message:     fmt.Sprintf(format, args...),
+		})
+	}
+
+	if !slices.Contains(componentTypes, c.Type) {
+		report(SeverityError, "unknown component type %q", c.Type)
+		return diags
+	}
+
+	if c.Style != "" {
+		if _, ok := findStyle(c.Style, styles); !ok {
+			report(SeverityWarning, "style %q does not exist", c.Style)
+		}
+	}
+	c = ResolveStyle(c, styles)
+
+	if c.Repeat != "" {
+		lintSource(c.Repeat, variables, func(severity Severity, format string, args ...any) {
+			report(severity, "repeat: "+format, args...)
+		})
+		if c.RepeatAs != "" {
+			if err := validVariableName(c.RepeatAs); err != nil {
+				report(SeverityError, "repeat name %q: %v", c.RepeatAs, err)
+			}
+		}
+		variables = append(slices.Clone(variables), repeatName(c), "loop")
+	}
+
+	call, generates := componentsCall(c)
+	if generates {
+		lintCall(call, report)
+		for _, arg := range call.Args {
+			lintExpr(arg, variables, report)
+		}
+	}
+
+	for _, field := range expandableFields(&c) {
+		if field.Name == "content" {
+			if !generates {
+				lintPlaceholders(*field.Value, variables, report)
+			}
+			continue
+		}
+		lintPlaceholders(*field.Value, variables, func(severity Severity, format string, args ...any) {
+			report(severity, field.Name+": "+format, args...)
+		})
+	}
+
+	// Fields worked out by placeholders are checked once they're expanded.
+	if !hasPlaceholders(c.Align) && !slices.Contains(alignments, c.Align) {
+		report(SeverityError, "unknown alignment %q", c.Align)
+	}
+
+	switch c.Type {
+	case TextComponent, HeaderComponent, MacroComponent:
+		if c.FontSize != "" && c.FontSize != "fit" && !hasPlaceholders(c.FontSize) {
+			size, err := strconv.Atoi(c.FontSize)
+			if err != nil {
+				report(SeverityError, "font size %q is not a number", c.FontSize)
+			} else if size <= 0 {
+				report(SeverityError, "font size must be greater than zero")
+			}
+		}
+
+	case QRComponent:
+		if c.Content == "" {
+			report(SeverityError, "QR code has no content")
+		}
+		if !c.Fit && c.Scale > 100 {
+			report(SeverityWarning, "QR code scale of %d%% is wider than the paper", c.Scale)
+		}
+
+	case DividerComponent:
+		if c.LineWidth <= 0 {
+			report(SeverityWarning, "divider has no line width")
+		}
+
+	case ImageComponent:
+		if c.Content == "" {
+			report(SeverityWarning, "no image selected")
+			break
+		}
+		if hasPlaceholders(c.Content) {
+			break
+		}
+		data, err := base64.StdEncoding.DecodeString(c.Content)
+		if err != nil {
+			report(SeverityError, "image data is not valid base64")
+			break
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			report(SeverityError, "image data is not a PNG or JPEG: %v", err)
+			break
+		}
+		switch {
+		case c.Fit:
+		case c.Width > printableWidth:
+			report(SeverityWarning, "image width of %dpx is wider than the paper (%dpx)", c.Width, printableWidth)
+		case c.Width == 0 && c.Scale > 100:
+			report(SeverityWarning, "image scale of %d%% is wider than the paper", c.Scale)
+		case c.Width == 0 && c.Scale == 0 && cfg.Width > printableWidth:
+			report(SeverityWarning, "image is %dpx wide and will be scaled down to fit the paper (%dpx)", cfg.Width, printableWidth)
+		}
+	}
+
+	return diags
+}
+
+// lintPlaceholders checks the placeholders in text parse, and lints each of
+// them with lintExpr.
+func lintPlaceholders(text string, variables []string, report func(Severity, string, ...any)) {
+	if !strings.Contains(text, "{{") {
+		return
+	}
+	segments, err := ParseTemplateText(text)
+	if err != nil {
+		report(SeverityError, "%v", err)
+		return
+	}
+
+	for _, segment := range segments {
+		if segment.Kind != PlaceholderSegment {
+			continue
+		}
+		expr, err := parseExpression(segment)
+		if err != nil {
+			report(SeverityError, "%v", err)
+			continue
+		}
+		lintExpr(expr, variables, report)
+	}
+}
+
+// lintSource lints an expression written outside of {{ }}.
+func lintSource(source string, variables []string, report func(Severity, string, ...any)) {
+	expr, err := parseSource(source)
+	if err != nil {
+		report(SeverityError, "%v", err)
+		return
+	}
+	lintExpr(expr, variables, report)
+}
+
+// lintExpr checks that every plugin call and filter in an expression exists
+// and is given the right number of arguments, and that the variables it uses
+// are defined.
+func lintExpr(expr Expr, variables []string, report func(Severity, string, ...any)) {
+	walkExpr(expr, func(e Expr) {
+		line, column := e.Pos()
+		switch e := e.(type) {
+		case *callExpr:
+			funcInfo := lintCall(e, report)
+			if funcInfo != nil && returnsComponents(funcInfo) {
+				report(SeverityError, "line %d, column %d: %s.%s returns components, so it must be on its own in a component's content",
+					line, column, e.Plugin, e.Function)
+			}
+		case *filterExpr:
+			filter, ok := filters[e.Name]
+			if !ok {
+				report(SeverityError, "line %d, column %d: unknown filter %s", line, column, e.Name)
+			} else if err := filter.checkArgs(len(e.Args)); err != nil {
+				report(SeverityError, "line %d, column %d: %s: %v", line, column, e.Name, err)
+			}
+		case *variableExpr:
+			if slices.Contains(variables, e.Name) {
+				break
+			}
+			if _, isPlugin := manifests[e.Name]; isPlugin || e.Name == stdNamespace {
+				report(SeverityError, "line %d, column %d: %s is a plugin, call its functions with parentheses", line, column, e.Name)
+			} else {
+				report(SeverityWarning, "line %d, column %d: variable %s is not defined and will be empty", line, column, e.Name)
+			}
+		}
+	})
+}
+
+// lintCall checks a plugin function exists and is given the right number of
+// arguments, and that any literal arguments suit it, returning it if it
+// exists.
+func lintCall(e *callExpr, report func(Severity, string, ...any)) *FunctionInfo {
+	if e.Plugin == stdNamespace {
+		f, ok := stdFunctions[e.Function]
+		if !ok {
+			report(SeverityError, "line %d, column %d: function %s not found in %s", e.Line, e.Column, e.Function, stdNamespace)
+		} else if err := f.checkArgs(len(e.Args)); err != nil {
+			report(SeverityError, "line %d, column %d: %s.%s %v", e.Line, e.Column, e.Plugin, e.Function, err)
+		}
+		return nil
+	}
+	funcInfo, err := lookupFunction(e.Plugin, e.Function)
+	if err != nil {
+		report(SeverityError, "line %d, column %d: %v", e.Line, e.Column, err)
+		return nil
+	}
+	if err := funcInfo.checkArgs(len(e.Args)); err != nil {
+		report(SeverityError, "line %d, column %d: %s.%s %v", e.Line, e.Column, e.Plugin, e.Function, err)
+		return funcInfo
+	}
+	// Arguments written out in the template can be checked now, such as a
+	// value which isn't in the param's enum.
+	for i, arg := range e.Args {
+		if lit, ok := arg.(*literalExpr); ok {
+			if _, err := funcInfo.Params[i].convert(fallbackLocale, lit.Value); err != nil {
+				report(SeverityError, "line %d, column %d: %s.%s %s: %v",
+					e.Line, e.Column, e.Plugin, e.Function, funcInfo.Params[i].label(i), err)
+			}
+		}
+	}
+	return funcInfo
+}
+
+func hasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func formatDiagnostics(diags []Diagnostic) string {
+	var buf bytes.Buffer
+	for _, d := range diags {
+		buf.WriteString(d.String())
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// makeDiagnosticsList renders diagnostics for a layout as a list of rows,
+// naming each component so it can be found in the editor.
+func makeDiagnosticsList(diags []Diagnostic, layout []Component) fyne.CanvasObject {
+	if len(diags) == 0 {
+		return container.NewHBox(widget.NewIcon(theme.ConfirmIcon()), widget.NewLabel("No problems found."))
+	}
+
+	rows := container.NewVBox()
+	for _, d := range diags {
+		icon := theme.WarningIcon()
+		if d.Severity == SeverityError {
+			icon = theme.ErrorIcon()
+		}
+
+		location := "Template"
+		if d.Index >= 0 && d.Index < len(layout) {
+			location = fmt.Sprintf("#%d %s", d.Index+1, layout[d.Index].Name)
+		}
+
+		label := widget.NewLabel(location + ": " + d.Message)
+		label.Wrapping = fyne.TextWrapWord
+		rows.Add(container.NewBorder(nil, nil, widget.NewIcon(icon), nil, label))
+	}
+	return rows
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LocaleFormat controls how dates, numbers and currency are written during
+// placeholder expansion.
+type LocaleFormat struct {
+	Code               string
+	Name               string
+	DateFormat         string
+	TimeFormat         string
+	DecimalSeparator   string
+	ThousandsSeparator string
+	Currency           string
+	// CurrencyPattern places the amount (%s) relative to the symbol (¤).
+	CurrencyPattern string
+}
+
+const defaultLocale = "en-GB"
+
+var locales = []LocaleFormat{
+	{Code: "en-GB", Name: "English (UK)", DateFormat: "02/01/2006", TimeFormat: "15:04", DecimalSeparator: ".", ThousandsSeparator: ",", Currency: "GBP", CurrencyPattern: "¤%s"},
+	{Code: "en-IE", Name: "English (Ireland)", DateFormat: "02/01/2006", TimeFormat: "15:04", DecimalSeparator: ".", ThousandsSeparator: ",", Currency: "EUR", CurrencyPattern: "¤%s"},
+	{Code: "en-US", Name: "English (US)", DateFormat: "01/02/2006", TimeFormat: "3:04 PM", DecimalSeparator: ".", ThousandsSeparator: ",", Currency: "USD", CurrencyPattern: "¤%s"},
+	{Code: "de-DE", Name: "Deutsch", DateFormat: "02.01.2006", TimeFormat: "15:04", DecimalSeparator: ",", ThousandsSeparator: ".", Currency: "EUR", CurrencyPattern: "%s ¤"},
+	{Code: "fr-FR", Name: "Français", DateFormat: "02/01/2006", TimeFormat: "15:04", DecimalSeparator: ",", ThousandsSeparator: " ", Currency: "EUR", CurrencyPattern: "%s ¤"},
+	{Code: "es-ES", Name: "Español", DateFormat: "02/01/2006", TimeFormat: "15:04", DecimalSeparator: ",", ThousandsSeparator: ".", Currency: "EUR", CurrencyPattern: "%s ¤"},
+	{Code: "it-IT", Name: "Italiano", DateFormat: "02/01/2006", TimeFormat: "15:04", DecimalSeparator: ",", ThousandsSeparator: ".", Currency: "EUR", CurrencyPattern: "%s ¤"},
+	{Code: "nl-NL", Name: "Nederlands", DateFormat: "02-01-2006", TimeFormat: "15:04", DecimalSeparator: ",", ThousandsSeparator: ".", Currency: "EUR", CurrencyPattern: "¤ %s"},
+}
+
+var currencySymbols = map[string]string{
+	"GBP": "£",
+	"EUR": "€",
+	"USD": "$",
+	"CHF": "CHF",
+	"SEK": "kr",
+	"DKK": "kr",
+	"NOK": "kr",
+	"PLN": "zł",
+}
+
+// fallbackLocale formats values outside of a receipt, such as in error
+// messages, and for receipts which don't choose a locale.
+var fallbackLocale = mustFindLocale(defaultLocale)
+
+// localeFrom gives the locale of the receipt ctx is filling in.
+func localeFrom(ctx context.Context) LocaleFormat {
+	if job := hostJobFrom(ctx); job != nil && job.Locale.Code != "" {
+		return job.Locale
+	}
+	return fallbackLocale
+}
+
+func findLocale(code string) (LocaleFormat, bool) {
+	for _, l := range locales {
+		if strings.EqualFold(l.Code, code) {
+			return l, true
+		}
+	}
+	return LocaleFormat{}, false
+}
+
+func mustFindLocale(code string) LocaleFormat {
+	l, ok := findLocale(code)
+	if !ok {
+		panic("unknown locale " + code)
+	}
+	return l
+}
+
+// localeOrDefault returns the named locale, or the default one if code is
+// empty or unknown.
+func localeOrDefault(code string) LocaleFormat {
+	if l, ok := findLocale(code); ok {
+		return l
+	}
+	return mustFindLocale(defaultLocale)
+}
+
+func localeCodes() []string {
+	codes := make([]string, len(locales))
+	for i, l := range locales {
+		codes[i] = l.Code
+	}
+	return codes
+}
+
+func (l LocaleFormat) FormatDate(t time.Time) string {
+	return t.Format(l.DateFormat)
+}
+
+func (l LocaleFormat) FormatTime(t time.Time) string {
+	return t.Format(l.TimeFormat)
+}
+
+// FormatPlainNumber writes a number as briefly as possible, without thousands
+// separators, so 3 is "3" rather than "3.000000".
+func (l LocaleFormat) FormatPlainNumber(n float64) string {
+	return strings.Replace(strconv.FormatFloat(n, 'f', -1, 64), ".", l.DecimalSeparator, 1)
+}
+
+// FormatNumber writes a number with thousands separators. A negative decimals
+// uses as many as needed.
+func (l LocaleFormat) FormatNumber(n float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(n), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(l.ThousandsSeparator)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	out := grouped.String()
+	if frac != "" {
+		out += l.DecimalSeparator + frac
+	}
+	if n < 0 && strings.Trim(s, "0.") != "" {
+		out = "-" + out
+	}
+	return out
+}
+
+// FormatCurrency writes an amount in the given currency, or the locale's own
+// currency if code is empty, using the locale's separators and layout.
+func (l LocaleFormat) FormatCurrency(n float64, code string) string {
+	if code == "" {
+		code = l.Currency
+	}
+	code = strings.ToUpper(code)
+	symbol, ok := currencySymbols[code]
+	if !ok {
+		symbol = code
+	}
+
+	amount := strings.Replace(l.CurrencyPattern, "%s", l.FormatNumber(math.Abs(n), 2), 1)
+	amount = strings.Replace(amount, "¤", symbol, 1)
+	if n < 0 && math.Round(math.Abs(n)*100) != 0 {
+		amount = "-" + amount
+	}
+	return amount
+}
+
+// registerLocaleModule exposes the active locale to plugins as a global
+// Locale table. The functions look up the locale when called, so plugins
+// always format for the receipt being expanded.
+func registerLocaleModule(L *lua.LState) {
+	module := L.NewTable()
+
+	timeArg := func(L *lua.LState, n int) time.Time {
+		if L.GetTop() >= n {
+			return time.Unix(int64(L.CheckNumber(n)), 0)
+		}
+		return time.Now()
+	}
+
+	L.SetFuncs(module, map[string]lua.LGFunction{
+		"code": func(L *lua.LState) int {
+			L.Push(lua.LString(localeFrom(L.Context()).Code))
+			return 1
+		},
+		"date": func(L *lua.LState) int {
+			L.Push(lua.LString(localeFrom(L.Context()).FormatDate(timeArg(L, 1))))
+			return 1
+		},
+		"time": func(L *lua.LState) int {
+			L.Push(lua.LString(localeFrom(L.Context()).FormatTime(timeArg(L, 1))))
+			return 1
+		},
+		"number": func(L *lua.LState) int {
+			n := float64(L.CheckNumber(1))
+			decimals := L.OptInt(2, -1)
+			L.Push(lua.LString(localeFrom(L.Context()).FormatNumber(n, decimals)))
+			return 1
+		},
+		"currency": func(L *lua.LState) int {
+			n := float64(L.CheckNumber(1))
+			code := L.OptString(2, "")
+			L.Push(lua.LString(localeFrom(L.Context()).FormatCurrency(n, code)))
+			return 1
+		},
+	})
+
+	L.SetGlobal("Locale", module)
+}
+
+func variantIndex(variants []TemplateVariant, locale string) int {
+	for i, v := range variants {
+		if v.Locale == locale {
+			return i
+		}
+	}
+	return -1
+}
+
+// defaultVariant stands for a template's main layout wherever a variant can
+// be chosen.
+const defaultVariant = "Default"
+
+// variantOptions lists the layouts a template can be edited or printed with.
+func variantOptions(variants []TemplateVariant) []string {
+	options := []string{defaultVariant}
+	for _, v := range variants {
+		options = append(options, v.Locale)
+	}
+	return options
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		locale   string
+		n        float64
+		decimals int
+		want     string
+	}{
+		{"en-GB", 0, 0, "0"},
+		{"en-GB", 999, 0, "999"},
+		{"en-GB", 1000, 0, "1,000"},
+		{"en-GB", 1234567.891, 2, "1,234,567.89"},
+		{"en-GB", 1234.5, -1, "1,234.5"},
+		{"en-GB", -1234.5, 2, "-1,234.50"},
+		{"en-GB", -0.001, 2, "0.00"},
+		{"en-GB", 0.5, 0, "0"},
+		{"en-GB", 1.5, 0, "2"},
+		{"de-DE", 1234567.891, 2, "1.234.567,89"},
+		{"fr-FR", 1234.5, 2, "1 234,50"},
+		{"nl-NL", -100000, 0, "-100.000"},
+	}
+
+	for _, tt := range tests {
+		got := mustFindLocale(tt.locale).FormatNumber(tt.n, tt.decimals)
+		if got != tt.want {
+			t.Errorf("%s FormatNumber(%v, %d) = %q, want %q", tt.locale, tt.n, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCurrency(t *testing.T) {
+	tests := []struct {
+		locale string
+		n      float64
+		code   string
+		want   string
+	}{
+		{"en-GB", 1234.5, "", "£1,234.50"},
+		{"en-GB", 3, "usd", "$3.00"},
+		{"en-GB", -2.5, "", "-£2.50"},
+		{"en-GB", -0.004, "", "£0.00"},
+		{"en-GB", 10, "JPY", "JPY10.00"},
+		{"en-US", 1000000, "", "$1,000,000.00"},
+		{"de-DE", 1234.5, "", "1.234,50 €"},
+		{"de-DE", -1234.5, "GBP", "-1.234,50 £"},
+		{"fr-FR", 12, "CHF", "12,00 CHF"},
+		{"nl-NL", 12.345, "", "€ 12,35"},
+	}
+
+	for _, tt := range tests {
+		got := mustFindLocale(tt.locale).FormatCurrency(tt.n, tt.code)
+		if got != tt.want {
+			t.Errorf("%s FormatCurrency(%v, %q) = %q, want %q", tt.locale, tt.n, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPlainNumber(t *testing.T) {
+	tests := []struct {
+		locale string
+		n      float64
+		want   string
+	}{
+		{"en-GB", 3, "3"},
+		{"en-GB", 1234.25, "1234.25"},
+		{"de-DE", 1234.25, "1234,25"},
+		{"en-GB", -0.5, "-0.5"},
+	}
+
+	for _, tt := range tests {
+		got := mustFindLocale(tt.locale).FormatPlainNumber(tt.n)
+		if got != tt.want {
+			t.Errorf("%s FormatPlainNumber(%v) = %q, want %q", tt.locale, tt.n, got, tt.want)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -15,21 +20,64 @@ import (
 //go:embed plugins/*
 var embeddedPlugins embed.FS
 
-var (
-	manifests map[string]*PluginManifest
-	luaVm     *lua.LState
+// manifests holds every loaded plugin by name. Each plugin runs in its own
+// Lua state, so plugins can't see each other's globals or modules.
+var manifests map[string]*PluginManifest
+
+// pluginsMu guards manifests and pluginEntries, as receipts are expanded away
+// from the UI goroutine. Plugins are only loaded and unloaded on the UI
+// goroutine while holding it, so code there can read them without it, but
+// code running elsewhere must hold it. It isn't held while a plugin runs;
+// each plugin has its own lock for that.
+var pluginsMu sync.Mutex
+
+const (
+	defaultPluginTimeout   = 5 * time.Second
+	maxPluginTimeout       = 60 * time.Second
+	defaultPluginCallDepth = 200
 )
 
+// options gives the Lua state options for a plugin's limits. The stack
+// doesn't grow, as growing it a step at a time is slow enough to get around
+// the timeout.
+func (limits PluginLimits) options() lua.Options {
+	options := lua.Options{
+		CallStackSize: defaultPluginCallDepth,
+		RegistrySize:  lua.RegistrySize,
+	}
+	if limits.CallDepth > 0 {
+		options.CallStackSize = limits.CallDepth
+	}
+	if limits.Registry > 0 {
+		options.RegistrySize = limits.Registry
+	}
+	return options
+}
+
+// timeout is how long a plugin's functions can run for. However long the
+// manifest asks for, it is never more than maxPluginTimeout, so a reload
+// waiting for a function to finish can't wait forever.
+func (limits PluginLimits) timeout() time.Duration {
+	if limits.Timeout <= 0 {
+		return defaultPluginTimeout
+	}
+	return min(time.Duration(limits.Timeout*float64(time.Second)), maxPluginTimeout)
+}
+
 func ConfigureLuaAndLoadPlugins() error {
-	luaVm = nil
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	closePluginStates()
 	manifests = make(map[string]*PluginManifest)
+	pluginEntries = nil
 
 	if settings.PluginPath == "" {
 		return fmt.Errorf("plugin path not set")
 	}
 
-	luaVm = lua.NewState()
-
+	// Plugins which fail to load are recorded in pluginEntries rather than
+	// stopping the rest from loading.
 	if err := loadPluginsFromFS(embeddedPlugins, "plugins"); err != nil {
 		return fmt.Errorf("error loading embedded plugins: %v", err)
 	}
@@ -41,77 +89,104 @@ func ConfigureLuaAndLoadPlugins() error {
 	return nil
 }
 
-func loadPluginsFromFS(fsys fs.FS, root string) error {
-	entries, err := fs.ReadDir(fsys, root)
-	if err != nil {
-		return err
+func closePluginStates() {
+	for _, manifest := range manifests {
+		closePluginState(manifest)
 	}
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			pluginPath := filepath.Join(root, entry.Name())
-			fmt.Println("Loading embedded plugin:", entry.Name())
+// closePluginState closes a plugin's Lua state once any function running in
+// it has finished.
+func closePluginState(manifest *PluginManifest) {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	if manifest.state != nil {
+		manifest.state.Close()
+		manifest.state = nil
+	}
+}
 
-			// Read manifest.json
-			manifestData, err := fs.ReadFile(fsys, filepath.Join(pluginPath, "manifest.json"))
-			if err != nil {
-				return fmt.Errorf("error reading manifest for %s: %v", entry.Name(), err)
-			}
+// newPluginState creates the sandboxed Lua state a plugin runs in. Modules
+// are only found in the plugin's own files, never on the default
+// package.path.
+func newPluginState(manifest *PluginManifest, files fs.FS, dataPath string) *lua.LState {
+	granted := grantedPermissions(manifest)
+	L := newSandboxedState(manifest.Limits.options(), files, newSandbox(dataPath, granted), granted.Env)
+	registerLocaleModule(L)
+	registerHostModule(L, manifest, dataPath)
+	preloadLuaModules(L)
 
-			var manifest PluginManifest
-			if err := json.Unmarshal(manifestData, &manifest); err != nil {
-				return fmt.Errorf("error parsing manifest for %s: %v", entry.Name(), err)
-			}
-			manifests[manifest.PluginName] = &manifest
+	packageTable := L.GetGlobal("package").(*lua.LTable)
+	L.SetField(packageTable, "path", lua.LString(""))
+	L.SetField(packageTable, "cpath", lua.LString(""))
+	registerLuaLoader(L, makePluginLoader(files))
 
-			// Register plugin-scoped loader for embedded plugin
-			registerLuaLoader(luaVm, makePluginLoaderFromFS(fsys, pluginPath))
+	L.SetGlobal(strings.ToUpper(manifest.PluginName)+"_DATA_FOLDER", lua.LString(dataPath))
+	setPluginConfigGlobal(L, manifest)
+	return L
+}
 
-			// Set data folder path
-			dataPath := filepath.Join(settings.PluginPath, entry.Name(), "data")
-			if err := os.MkdirAll(dataPath, 0755); err != nil {
-				return fmt.Errorf("failed to create data folder for embedded plugin %s: %v", entry.Name(), err)
-			}
-			luaVm.SetGlobal(strings.ToUpper(manifest.PluginName)+"_DATA_FOLDER", lua.LString(dataPath))
+// runWithTimeout runs Lua code in a plugin's state, stopping it if it runs for
+// longer than the plugin's timeout.
+func runWithTimeout(manifest *PluginManifest, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manifest.Limits.timeout())
+	defer cancel()
+	manifest.state.SetContext(ctx)
+	defer manifest.state.RemoveContext()
+
+	err := fn()
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("stopped after running for %v", manifest.Limits.timeout())
+	}
+	return err
+}
 
-			// Load main.lua
-			luaCode, err := fs.ReadFile(fsys, filepath.Join(pluginPath, "main.lua"))
-			if err != nil {
-				return fmt.Errorf("error reading main.lua for %s: %v", entry.Name(), err)
-			}
-			if err := luaVm.DoString(string(luaCode)); err != nil {
-				return fmt.Errorf("failed to load main.lua for %s: %v", entry.Name(), err)
-			}
+// addPlugin records a loaded plugin, closing the state of any plugin it
+// replaces.
+func addPlugin(manifest *PluginManifest) {
+	if old, ok := manifests[manifest.PluginName]; ok {
+		closePluginState(old)
+	}
+	manifests[manifest.PluginName] = manifest
+}
+
+// loadPluginsFromFS loads the plugins embedded in Receiptify. A plugin which
+// fails to load is recorded in pluginEntries and the rest are still loaded.
+func loadPluginsFromFS(fsys fs.FS, root string) error {
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return err
+	}
 
-			fmt.Printf("Successfully loaded embedded plugin %s %s from %s\n",
-				manifest.PluginName, manifest.Version, entry.Name())
+	for _, entry := range entries {
+		if entry.IsDir() {
+			loadPluginFromFS(fsys, root, entry.Name())
 		}
 	}
 
 	return nil
 }
 
-func makePluginLoaderFromFS(fsys fs.FS, pluginPath string) lua.LGFunction {
-	return func(L *lua.LState) int {
-		moduleName := L.ToString(1)
-		filename := moduleName + ".lua"
-		fullPath := filepath.Join(pluginPath, filename)
-
-		data, err := fs.ReadFile(fsys, fullPath)
-		if err != nil {
-			return 0 // not found
-		}
+// loadPluginFromFS loads one of the plugins embedded in Receiptify.
+func loadPluginFromFS(fsys fs.FS, root string, folderName string) error {
+	fmt.Println("Loading embedded plugin:", folderName)
 
-		fn, err := L.LoadString(string(data))
-		if err != nil {
-			L.RaiseError("error compiling module %s: %v", moduleName, err)
-		}
+	entry := &pluginEntry{
+		Folder:   folderName,
+		Embedded: true,
+		DataPath: filepath.Join(settings.PluginPath, folderName, "data"),
+	}
+	addPluginEntry(entry)
 
-		L.Push(fn)
-		return 1
+	files, err := fs.Sub(fsys, path.Join(root, folderName))
+	if err != nil {
+		return recordLoadError(entry, &pluginLoadError{Phase: phaseSetup, Err: err})
 	}
+	return recordLoadError(entry, loadPlugin(entry, files))
 }
 
+// loadPluginsFromDisk loads the plugins in the plugin path. A plugin which
+// fails to load is recorded in pluginEntries and the rest are still loaded.
 func loadPluginsFromDisk(pluginDir string) error {
 	pluginFolders, err := os.ReadDir(pluginDir)
 	if err != nil {
@@ -122,64 +197,140 @@ func loadPluginsFromDisk(pluginDir string) error {
 	}
 
 	for _, folder := range pluginFolders {
-		if folder.IsDir() {
-			pluginPath := filepath.Join(pluginDir, folder.Name())
+		// Hidden folders include plugins part way through being installed.
+		if folder.IsDir() && !strings.HasPrefix(folder.Name(), ".") {
+			loadPluginFromDisk(filepath.Join(pluginDir, folder.Name()))
+		}
+	}
 
-			manifestPath := filepath.Join(pluginPath, "manifest.json")
-			if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
-				continue
-			}
+	return nil
+}
 
-			fmt.Println("Loading plugin from disk:", folder.Name())
+// loadPluginFromDisk loads the plugin in one folder of the plugin path.
+// Folders without a manifest are skipped.
+func loadPluginFromDisk(pluginPath string) error {
+	folderName := filepath.Base(pluginPath)
 
-			manifestData, err := os.ReadFile(manifestPath)
-			if err != nil {
-				return fmt.Errorf("error reading manifest for %s: %v", folder.Name(), err)
-			}
+	manifestPath := filepath.Join(pluginPath, "manifest.json")
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		return nil
+	}
 
-			var manifest PluginManifest
-			if err := json.Unmarshal(manifestData, &manifest); err != nil {
-				return fmt.Errorf("error parsing manifest for %s: %v", folder.Name(), err)
-			}
-			manifests[manifest.PluginName] = &manifest
+	fmt.Println("Loading plugin from disk:", folderName)
 
-			// Register plugin-scoped loader for disk plugin
-			registerLuaLoader(luaVm, makePluginLoaderFromDisk(pluginPath))
+	entry := &pluginEntry{
+		Folder:   folderName,
+		Path:     pluginPath,
+		DataPath: filepath.Join(pluginPath, "data"),
+	}
+	addPluginEntry(entry)
+	return recordLoadError(entry, loadPlugin(entry, os.DirFS(pluginPath)))
+}
 
-			// Set data folder path
-			dataPath := filepath.Join(pluginPath, "data")
-			if err := os.MkdirAll(dataPath, 0755); err != nil {
-				return fmt.Errorf("failed to create data folder for plugin %s: %v", folder.Name(), err)
-			}
+// recordLoadError remembers why a plugin failed to load, for the plugin
+// manager, and gives the error to show the user.
+func recordLoadError(entry *pluginEntry, loadErr *pluginLoadError) error {
+	if loadErr == nil {
+		return nil
+	}
+	entry.Err = loadErr
+	fmt.Printf("Could not load plugin %s: %v\n", entry.Folder, loadErr)
+	return fmt.Errorf("could not load plugin %s: %v", entry.Folder, loadErr)
+}
+
+// loadPlugin reads a plugin's manifest from its files and runs its main.lua
+// in a new Lua state. The plugin is only added to manifests if it loads.
+func loadPlugin(entry *pluginEntry, files fs.FS) *pluginLoadError {
+	manifestData, err := fs.ReadFile(files, "manifest.json")
+	if err != nil {
+		return &pluginLoadError{Phase: phaseManifest, File: "manifest.json", Err: err}
+	}
 
-			luaVm.SetGlobal(strings.ToUpper(manifest.PluginName)+"_DATA_FOLDER", lua.LString(dataPath))
+	var manifest PluginManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return manifestLoadError(manifestData, err)
+	}
+	entry.Manifest = &manifest
+	if err := validateConfigFields(manifest.Config); err != nil {
+		return &pluginLoadError{Phase: phaseManifest, File: "manifest.json", Err: err}
+	}
+	if err := validateFunctions(manifest.Functions); err != nil {
+		return &pluginLoadError{Phase: phaseManifest, File: "manifest.json", Err: err}
+	}
+	if manifest.PluginName == stdNamespace {
+		return &pluginLoadError{Phase: phaseManifest, File: "manifest.json",
+			Err: fmt.Errorf("the name %s is reserved for built-in functions", stdNamespace)}
+	}
+	if pluginDisabled(manifest.PluginName) {
+		fmt.Printf("Skipping disabled plugin %s\n", manifest.PluginName)
+		entry.Disabled = true
+		return nil
+	}
+	if !entry.Embedded {
+		manifest.dir = entry.Path
+	}
 
-			// Load main.lua
-			mainLuaPath := filepath.Join(pluginPath, "main.lua")
-			if err := luaVm.DoFile(mainLuaPath); err != nil {
-				return fmt.Errorf("failed to load main.lua for plugin %s: %v", folder.Name(), err)
-			}
+	if err := os.MkdirAll(entry.DataPath, 0755); err != nil {
+		return &pluginLoadError{Phase: phaseSetup, Err: fmt.Errorf("could not create data folder: %v", err)}
+	}
 
-			fmt.Printf("Successfully loaded %s %s from %s\n",
-				manifest.PluginName, manifest.Version, folder.Name())
-		}
+	luaCode, err := fs.ReadFile(files, "main.lua")
+	if err != nil {
+		return &pluginLoadError{Phase: phaseCompile, File: "main.lua", Err: err}
 	}
 
+	manifest.state = newPluginState(&manifest, files, entry.DataPath)
+	fn, err := manifest.state.Load(bytes.NewReader(luaCode), "main.lua")
+	if err == nil {
+		err = runWithTimeout(&manifest, func() error {
+			manifest.state.Push(fn)
+			return manifest.state.PCall(0, 0, nil)
+		})
+	}
+	if err != nil {
+		manifest.state.Close()
+		manifest.state = nil
+		return luaLoadError(phaseRun, "main.lua", err)
+	}
+	if err := checkExports(manifest.state, &manifest); err != nil {
+		manifest.state.Close()
+		manifest.state = nil
+		return &pluginLoadError{Phase: phaseExports, File: "main.lua", Err: err}
+	}
+	addPlugin(&manifest)
+
+	fmt.Printf("Successfully loaded %s %s from %s\n", manifest.PluginName, manifest.Version, entry.Folder)
 	return nil
 }
 
-func makePluginLoaderFromDisk(pluginPath string) lua.LGFunction {
+// reloadPluginFromDisk unloads whichever plugin was loaded from a folder and
+// loads it again, leaving the other plugins alone.
+func reloadPluginFromDisk(pluginPath string) error {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	for name, manifest := range manifests {
+		if manifest.dir == pluginPath {
+			closePluginState(manifest)
+			delete(manifests, name)
+		}
+	}
+	removePluginEntry(pluginEntrySource(false, filepath.Base(pluginPath), pluginPath))
+	return loadPluginFromDisk(pluginPath)
+}
+
+// makePluginLoader finds modules for require in a plugin's own files.
+func makePluginLoader(files fs.FS) lua.LGFunction {
 	return func(L *lua.LState) int {
 		moduleName := L.ToString(1)
 		filename := moduleName + ".lua"
-		fullPath := filepath.Join(pluginPath, filename)
 
-		data, err := os.ReadFile(fullPath)
+		data, err := fs.ReadFile(files, filename)
 		if err != nil {
 			return 0 // not found
 		}
 
-		fn, err := L.LoadString(string(data))
+		fn, err := L.Load(strings.NewReader(string(data)), filename)
 		if err != nil {
 			L.RaiseError("error compiling module %s: %v", moduleName, err)
 		}
@@ -199,160 +350,211 @@ func registerLuaLoader(L *lua.LState, loader lua.LGFunction) {
 	searchersTable.Append(L.NewFunction(loader))
 }
 
-func RunPlugin(token string) ([]string, error) {
-	callStr := strings.TrimSuffix(strings.TrimPrefix(token, "{{"), "}}")
-	dotIndex := strings.Index(callStr, ".")
-	if dotIndex < 1 {
-		return []string{}, fmt.Errorf("call must be pluginName.functionName")
-	}
-
-	pluginName := callStr[:dotIndex]
-	funcCall := callStr[dotIndex+1:]
-
+func lookupFunction(pluginName string, funcName string) (*FunctionInfo, error) {
 	manifest, exists := manifests[pluginName]
 	if !exists {
-		return []string{}, fmt.Errorf("plugin %s not found", pluginName)
-	}
-
-	parenIndex := strings.Index(funcCall, "(")
-	if parenIndex == -1 || !strings.HasSuffix(funcCall, ")") {
-		return []string{}, fmt.Errorf("function call is missing parentheses")
+		return nil, fmt.Errorf("plugin %s not found", pluginName)
 	}
-	funcName := funcCall[:parenIndex]
-	argsStr := funcCall[parenIndex+1 : len(funcCall)-1]
 
-	// Find function info in manifest
-	var funcInfo *FunctionInfo
 	for _, f := range manifest.Functions {
 		if f.Name == funcName {
-			funcInfo = &f
-			break
+			return &f, nil
 		}
 	}
-	if funcInfo == nil {
-		return []string{}, fmt.Errorf("function %s not found in %s manifest", funcName, pluginName)
+	return nil, fmt.Errorf("function %s not found in %s manifest", funcName, pluginName)
+}
+
+// RunPlugin calls a plugin function, converting the arguments to the types in
+// its manifest. Returns are converted with luaToGo. The function is stopped
+// if ctx is cancelled or it runs for longer than the plugin's timeout.
+func RunPlugin(ctx context.Context, call pluginCall) (rets []any, err error) {
+	defer func() {
+		if err != nil {
+			pluginsMu.Lock()
+			recordPluginCallError(call.Plugin, err)
+			pluginsMu.Unlock()
+		}
+	}()
+
+	pluginName := call.Plugin
+	funcName := call.Function
+
+	pluginsMu.Lock()
+	funcInfo, err := lookupFunction(pluginName, funcName)
+	manifest := manifests[pluginName]
+	pluginsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	L := manifest.state
+	if L == nil {
+		return nil, fmt.Errorf("plugin %s was unloaded", pluginName)
 	}
 
 	// Get plugin table from Lua
-	pluginTable := luaVm.GetGlobal(pluginName)
+	pluginTable := L.GetGlobal(pluginName)
 	if pluginTable == lua.LNil {
-		return []string{}, fmt.Errorf("plugin %s not found", pluginName)
+		return nil, fmt.Errorf("plugin %s not found", pluginName)
 	}
 	pluginTableTable, ok := pluginTable.(*lua.LTable)
 	if !ok {
-		return []string{}, fmt.Errorf("plugin %s is not a Lua table", pluginName)
+		return nil, fmt.Errorf("plugin %s is not a Lua table", pluginName)
 	}
 
-	fn := luaVm.GetField(pluginTableTable, funcName)
+	if previewing(ctx) && funcInfo.Preview != "" {
+		funcName = funcInfo.Preview
+	}
+	fn := L.GetField(pluginTableTable, funcName)
 	if fn == lua.LNil {
-		return []string{}, fmt.Errorf("function %s not found in plugin %s", funcName, pluginName)
+		return nil, fmt.Errorf("function %s not found in plugin %s", funcName, pluginName)
 	}
 
-	// Parse arguments
-	args := []lua.LValue{}
-	argsStr = strings.TrimSpace(argsStr)
-	if len(argsStr) > 0 {
-		argParts := splitArgs(argsStr)
-		if len(argParts) != len(funcInfo.Params) {
-			return []string{}, fmt.Errorf("expected %d args, got %d", len(funcInfo.Params), len(argParts))
+	// Convert arguments, filling in the defaults of optional ones which
+	// were left out
+	if err := funcInfo.checkArgs(len(call.Args)); err != nil {
+		return nil, fmt.Errorf("%s.%s %v", pluginName, call.Function, err)
+	}
+	args := make([]lua.LValue, len(funcInfo.Params))
+	for i, param := range funcInfo.Params {
+		arg := param.Default
+		if i < len(call.Args) {
+			arg = call.Args[i]
 		}
-
-		for i, p := range argParts {
-			p = strings.TrimSpace(p)
-			switch funcInfo.Params[i] {
-			case "string":
-				if len(p) >= 2 && p[0] == '"' && p[len(p)-1] == '"' {
-					args = append(args, lua.LString(p[1:len(p)-1]))
-				} else {
-					return []string{}, fmt.Errorf("string args must be in quotes")
-				}
-			case "number":
-				var num float64
-				_, err := fmt.Sscanf(p, "%f", &num)
-				if err != nil {
-					return []string{}, fmt.Errorf("failed to parse number arg: %s", p)
-				}
-				args = append(args, lua.LNumber(num))
-			case "boolean":
-				switch p {
-				case "true":
-					args = append(args, lua.LBool(true))
-				case "false":
-					args = append(args, lua.LBool(false))
-				default:
-					return []string{}, fmt.Errorf("boolean args must be true or false")
-				}
-			default:
-				return []string{}, fmt.Errorf("unsupported param type: %s", funcInfo.Params[i])
-			}
+		if arg == nil && i >= len(call.Args) {
+			args[i] = lua.LNil
+			continue
 		}
+		converted, err := param.convert(localeFrom(ctx), arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", param.label(i), err)
+		}
+		args[i] = goToLua(L, converted)
 	}
 
 	// Call function with correct number of returns
+	timeout := manifest.Limits.timeout()
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	L.SetContext(callCtx)
+	defer L.RemoveContext()
+
 	numRets := len(funcInfo.Returns)
-	err := luaVm.CallByParam(lua.P{
+	err = L.CallByParam(lua.P{
 		Fn:      fn,
 		NRet:    numRets,
 		Protect: true,
 	}, args...)
 	if err != nil {
-		return []string{}, fmt.Errorf("error calling Lua function: %v", err)
+		switch {
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("%s.%s was cancelled", pluginName, call.Function)
+		case callCtx.Err() != nil:
+			return nil, fmt.Errorf("%s.%s was stopped after running for %v", pluginName, call.Function, timeout)
+		}
+		return nil, fmt.Errorf("error calling Lua function: %v", err)
 	}
 
 	// Collect returns
-	rets := make([]string, 0, numRets)
+	rets = make([]any, 0, numRets)
 	for i := numRets; i >= 1; i-- {
-		ret := luaVm.Get(-i)
-		typ := funcInfo.Returns[numRets-i]
-		switch typ {
-		case "string":
-			if str, ok := ret.(lua.LString); ok {
-				rets = append(rets, string(str))
-			} else {
-				rets = append(rets, ret.String())
-			}
-		case "number":
-			if num, ok := ret.(lua.LNumber); ok {
-				rets = append(rets, fmt.Sprintf("%f", float64(num)))
-			} else {
-				rets = append(rets, ret.String())
-			}
-		case "boolean":
-			if b, ok := ret.(lua.LBool); ok {
-				rets = append(rets, fmt.Sprintf("%t", bool(b)))
-			} else {
-				rets = append(rets, ret.String())
-			}
-		default:
-			rets = append(rets, ret.String())
+		ret, err := luaToGo(L.Get(-i), 0)
+		if err == nil {
+			ret, err = funcInfo.Returns[numRets-i].apply(localeFrom(ctx), ret)
+		}
+		if err != nil {
+			L.Pop(numRets)
+			return nil, fmt.Errorf("return value %d: %v", numRets-i+1, err)
 		}
+		rets = append(rets, ret)
 	}
-	luaVm.Pop(numRets)
+	L.Pop(numRets)
 
 	return rets, nil
 }
 
-func splitArgs(s string) []string {
-	var args []string
-	inQuotes := false
-	escaped := false
-	start := 0
-
-	for i, c := range s {
-		if c == '\\' && !escaped {
-			escaped = true
-			continue
+// maxTableDepth stops tables which contain themselves from being converted
+// forever.
+const maxTableDepth = 32
+
+// luaToGo converts a value returned by a plugin. Tables with the keys 1..n
+// become []any lists, and other tables become map[string]any.
+func luaToGo(value lua.LValue, depth int) (any, error) {
+	switch v := value.(type) {
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LBool:
+		return bool(v), nil
+	case *lua.LNilType:
+		return nil, nil
+	case *lua.LTable:
+		if depth >= maxTableDepth {
+			return nil, fmt.Errorf("tables are nested too deeply")
 		}
-		if c == '"' && !escaped {
-			inQuotes = !inQuotes
+
+		if n, ok := luaList(v); ok {
+			list := make([]any, n)
+			for i := range n {
+				item, err := luaToGo(v.RawGetInt(i+1), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = item
+			}
+			return list, nil
 		}
-		if c == ',' && !inQuotes {
-			args = append(args, strings.TrimSpace(s[start:i]))
-			start = i + 1
+
+		table := map[string]any{}
+		var err error
+		v.ForEach(func(key lua.LValue, item lua.LValue) {
+			if err != nil {
+				return
+			}
+			var converted any
+			converted, err = luaToGo(item, depth+1)
+			table[fallbackLocale.toText(luaKey(key))] = converted
+		})
+		if err != nil {
+			return nil, err
 		}
-		escaped = false
+		return table, nil
+	}
+	return value.String(), nil
+}
+
+func luaKey(key lua.LValue) any {
+	if n, ok := key.(lua.LNumber); ok {
+		return float64(n)
 	}
-	args = append(args, strings.TrimSpace(s[start:]))
+	return key.String()
+}
 
-	return args
+// goToLua converts a value from an expression so it can be passed to a
+// plugin.
+func goToLua(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case []any:
+		table := L.NewTable()
+		for _, item := range v {
+			table.Append(goToLua(L, item))
+		}
+		return table
+	case map[string]any:
+		table := L.NewTable()
+		for k, item := range v {
+			table.RawSetString(k, goToLua(L, item))
+		}
+		return table
+	}
+	return lua.LNil
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// preloadLuaModules makes the json, csv and time modules available to a
+// plugin through require.
+func preloadLuaModules(L *lua.LState) {
+	L.PreloadModule("json", openJSONModule)
+	L.PreloadModule("csv", openCSVModule)
+	L.PreloadModule("time", openTimeModule)
+}
+
+// luaList reports whether a table is a list, with the keys 1 to n and no
+// others, and gives its length. An empty table is an empty list.
+func luaList(table *lua.LTable) (int, bool) {
+	count := 0
+	table.ForEach(func(lua.LValue, lua.LValue) { count++ })
+	n := table.Len()
+	return n, n == count
+}
+
+// formatNumber writes a number the same way whatever the locale, for formats
+// other programs read.
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// luaToJSON converts a Lua value for encoding. Unlike luaToGo it refuses
+// values JSON can't hold, rather than writing them as text.
+func luaToJSON(value lua.LValue, null lua.LValue, depth int) (any, error) {
+	if value == null {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, fmt.Errorf("can't encode %v as JSON", v)
+		}
+		return float64(v), nil
+	case *lua.LTable:
+		if depth >= maxTableDepth {
+			return nil, fmt.Errorf("tables are nested too deeply")
+		}
+		if n, ok := luaList(v); ok {
+			list := make([]any, n)
+			for i := range n {
+				item, err := luaToJSON(v.RawGetInt(i+1), null, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = item
+			}
+			return list, nil
+		}
+
+		object := map[string]any{}
+		var err error
+		v.ForEach(func(key lua.LValue, item lua.LValue) {
+			if err != nil {
+				return
+			}
+			var name string
+			switch k := key.(type) {
+			case lua.LString:
+				name = string(k)
+			case lua.LNumber:
+				name = formatNumber(float64(k))
+			default:
+				err = fmt.Errorf("can't encode a table with a %s key as JSON", key.Type())
+				return
+			}
+			object[name], err = luaToJSON(item, null, depth+1)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	}
+	return nil, fmt.Errorf("can't encode a %s as JSON", value.Type())
+}
+
+// jsonToLua converts a decoded JSON value. null becomes the json.null value
+// rather than nil, so lists keep their length.
+func jsonToLua(L *lua.LState, value any, null lua.LValue) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return null
+	case []any:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(jsonToLua(L, item, null))
+		}
+		return table
+	case map[string]any:
+		table := L.CreateTable(0, len(v))
+		for k, item := range v {
+			table.RawSetString(k, jsonToLua(L, item, null))
+		}
+		return table
+	}
+	return goToLua(L, value)
+}
+
+func openJSONModule(L *lua.LState) int {
+	module := L.NewTable()
+	null := L.NewUserData()
+	module.RawSetString("null", null)
+
+	L.SetFuncs(module, map[string]lua.LGFunction{
+		"encode": func(L *lua.LState) int {
+			value, err := luaToJSON(L.CheckAny(1), null, 0)
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			indent := ""
+			if options := L.OptTable(2, nil); options != nil {
+				indent = lua.LVAsString(options.RawGetString("indent"))
+			}
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", indent)
+			if err := encoder.Encode(value); err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(lua.LString(strings.TrimSuffix(buf.String(), "\n")))
+			return 1
+		},
+		"decode": func(L *lua.LState) int {
+			var value any
+			if err := json.Unmarshal([]byte(L.CheckString(1)), &value); err != nil {
+				L.RaiseError("invalid JSON: %v", err)
+			}
+			L.Push(jsonToLua(L, value, null))
+			return 1
+		},
+	})
+
+	L.Push(module)
+	return 1
+}
+
+// csvOptions reads the separator and whether there is a header row from an
+// options table.
+func csvOptions(L *lua.LState, n int) (options *lua.LTable, separator rune) {
+	options = L.OptTable(n, L.NewTable())
+	separator = ','
+	if s := options.RawGetString("separator"); s != lua.LNil {
+		runes := []rune(lua.LVAsString(s))
+		if len(runes) != 1 {
+			L.ArgError(n, "separator must be a single character")
+		}
+		separator = runes[0]
+	}
+	return options, separator
+}
+
+// csvField writes a value for a CSV cell.
+func csvField(value lua.LValue) string {
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return ""
+	case lua.LNumber:
+		return formatNumber(float64(v))
+	}
+	return value.String()
+}
+
+func openCSVModule(L *lua.LState) int {
+	module := L.NewTable()
+
+	L.SetFuncs(module, map[string]lua.LGFunction{
+		"read": func(L *lua.LState) int {
+			text := L.CheckString(1)
+			options, separator := csvOptions(L, 2)
+			reader := csv.NewReader(strings.NewReader(text))
+			reader.Comma = separator
+			records, err := reader.ReadAll()
+			if err != nil {
+				L.RaiseError("invalid CSV: %v", err)
+			}
+
+			rows := L.NewTable()
+			if options.RawGetString("header") == lua.LFalse {
+				for _, record := range records {
+					row := L.CreateTable(len(record), 0)
+					for _, field := range record {
+						row.Append(lua.LString(field))
+					}
+					rows.Append(row)
+				}
+				L.Push(rows)
+				return 1
+			}
+
+			header := L.NewTable()
+			if len(records) > 0 {
+				for _, name := range records[0] {
+					header.Append(lua.LString(name))
+				}
+				for _, record := range records[1:] {
+					row := L.CreateTable(0, len(record))
+					for i, field := range record {
+						row.RawSetString(records[0][i], lua.LString(field))
+					}
+					rows.Append(row)
+				}
+			}
+			L.Push(rows)
+			L.Push(header)
+			return 2
+		},
+		"write": func(L *lua.LState) int {
+			rows := L.CheckTable(1)
+			options, separator := csvOptions(L, 2)
+
+			// The columns are the header if one is given, or else the names
+			// used in the rows in alphabetical order. Rows which are lists
+			// are written as they are.
+			var columns []string
+			if header, ok := options.RawGetString("header").(*lua.LTable); ok {
+				header.ForEach(func(_ lua.LValue, name lua.LValue) {
+					columns = append(columns, lua.LVAsString(name))
+				})
+			} else {
+				rows.ForEach(func(_ lua.LValue, row lua.LValue) {
+					table, ok := row.(*lua.LTable)
+					if !ok {
+						return
+					}
+					if _, isList := luaList(table); isList {
+						return
+					}
+					table.ForEach(func(key lua.LValue, _ lua.LValue) {
+						if name := lua.LVAsString(key); !slices.Contains(columns, name) {
+							columns = append(columns, name)
+						}
+					})
+				})
+				slices.Sort(columns)
+			}
+
+			var buf bytes.Buffer
+			writer := csv.NewWriter(&buf)
+			writer.Comma = separator
+			if len(columns) > 0 {
+				writer.Write(columns)
+			}
+			n, _ := luaList(rows)
+			for i := range n {
+				table, ok := rows.RawGetInt(i + 1).(*lua.LTable)
+				if !ok {
+					L.RaiseError("row %d must be a table", i+1)
+				}
+				var record []string
+				if length, isList := luaList(table); isList && length > 0 {
+					for j := range length {
+						record = append(record, csvField(table.RawGetInt(j+1)))
+					}
+				} else {
+					for _, column := range columns {
+						record = append(record, csvField(table.RawGetString(column)))
+					}
+				}
+				writer.Write(record)
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(lua.LString(buf.String()))
+			return 1
+		},
+	})
+
+	L.Push(module)
+	return 1
+}
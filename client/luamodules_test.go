@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// evalLua runs a chunk and gives back the value it returns.
+func evalLua(t *testing.T, L *lua.LState, source string) lua.LValue {
+	t.Helper()
+	if err := L.DoString(source); err != nil {
+		t.Fatalf("%s: %v", source, err)
+	}
+	value := L.Get(-1)
+	L.Pop(1)
+	return value
+}
+
+func TestLuaToJSON(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	preloadLuaModules(L)
+	evalLua(t, L, `json = require("json") return nil`)
+	null := L.GetField(L.GetGlobal("json"), "null")
+
+	tests := []struct {
+		source string
+		want   any
+	}{
+		{`return {}`, []any{}},
+		{`return {1, "two", true}`, []any{1.0, "two", true}},
+		{`return {[1] = "a", [2] = "b"}`, []any{"a", "b"}},
+		{`return {1, 2, x = 3}`, map[string]any{"1": 1.0, "2": 2.0, "x": 3.0}},
+		{`return {[1] = "a", [3] = "c"}`, map[string]any{"1": "a", "3": "c"}},
+		{`return {[2] = "b"}`, map[string]any{"2": "b"}},
+		{`return {[1.5] = "x"}`, map[string]any{"1.5": "x"}},
+		{`return {name = "tea", tags = {}}`, map[string]any{"name": "tea", "tags": []any{}}},
+		{`return {json.null, 2}`, []any{nil, 2.0}},
+		{`return {a = {b = {1}}}`, map[string]any{"a": map[string]any{"b": []any{1.0}}}},
+		{`return nil`, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := luaToJSON(evalLua(t, L, tt.source), null, 0)
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestLuaToJSONErrors(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`return function() end`, "can't encode a function as JSON"},
+		{`return {f = print}`, "can't encode a function as JSON"},
+		{`return {[true] = 1}`, "can't encode a table with a boolean key as JSON"},
+		{`return 0/0`, "as JSON"},
+		{`return {1/0}`, "can't encode +Inf as JSON"},
+		{`local t = {} t.self = t return t`, "tables are nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		_, err := luaToJSON(evalLua(t, L, tt.source), lua.LNil, 0)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want it to contain %q", tt.source, err, tt.want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	preloadLuaModules(L)
+
+	got := evalLua(t, L, `
+		local json = require("json")
+		local value = json.decode('{"b": [1, null, "x"], "a": {"c": true}, "e": []}')
+		return json.encode(value)
+	`)
+	want := `{"a":{"c":true},"b":[1,null,"x"],"e":[]}`
+	if got.String() != want {
+		t.Errorf("round trip = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // so time zones work on computers without a zone database
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Times in the time module are seconds since 1970 UTC, the same as os.time,
+// so they can be passed to the locale module and compared or subtracted.
+
+func secondsToTime(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+func timeToSeconds(t time.Time) lua.LNumber {
+	return lua.LNumber(float64(t.Unix()) + float64(t.Nanosecond())/1e9)
+}
+
+// timeZone finds a time zone by name: "local", "UTC" or an IANA name such as
+// "Europe/London".
+func timeZone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "", "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+var (
+	monthNames = []string{"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December"}
+	dayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+)
+
+// strftimeLayouts gives the Go layout for each strftime directive the time
+// module understands.
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST",
+}
+
+// formatTime formats a time with strftime directives such as %Y-%m-%d. Each
+// directive is formatted on its own, so the text around them is written
+// exactly as it is.
+func formatTime(t time.Time, format string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("format ends with %%")
+		}
+		if format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		layout, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unknown directive %%%c", format[i])
+		}
+		b.WriteString(t.Format(layout))
+	}
+	return b.String(), nil
+}
+
+// parseTime reads a time written with strftime directives. Parts the format
+// leaves out default to 1 January 1970 at midnight, in loc unless the text
+// has an offset.
+func parseTime(text string, format string, loc *time.Location) (time.Time, error) {
+	year, month, day, hour, minute, second := 1970, 1, 1, 0, 0, 0
+	yday, pm, hasPM := 0, false, false
+
+	pos := 0
+	number := func(directive byte, maxDigits int) (int, error) {
+		if directive == 'e' && pos < len(text) && text[pos] == ' ' {
+			pos++
+		}
+		start := pos
+		for pos < len(text) && pos-start < maxDigits && text[pos] >= '0' && text[pos] <= '9' {
+			pos++
+		}
+		if start == pos {
+			return 0, fmt.Errorf("expected a number for %%%c at %q", directive, text[start:])
+		}
+		return strconv.Atoi(text[start:pos])
+	}
+	name := func(directive byte, names []string, short bool) (int, error) {
+		for i, n := range names {
+			if short {
+				n = n[:3]
+			}
+			if len(text)-pos >= len(n) && strings.EqualFold(text[pos:pos+len(n)], n) {
+				pos += len(n)
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("expected a name for %%%c at %q", directive, text[pos:])
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) || format[i+1] == '%' {
+			if format[i] == '%' {
+				i++
+			}
+			if pos == len(text) || text[pos] != format[i] {
+				return time.Time{}, fmt.Errorf("%q doesn't match the format %q", text, format)
+			}
+			pos++
+			continue
+		}
+		i++
+		var err error
+		switch d := format[i]; d {
+		case 'Y':
+			year, err = number(d, 4)
+		case 'y':
+			year, err = number(d, 2)
+			// As in POSIX, 69 to 99 are the 1900s and 00 to 68 the 2000s.
+			if year < 69 {
+				year += 2000
+			} else {
+				year += 1900
+			}
+		case 'm':
+			month, err = number(d, 2)
+		case 'd', 'e':
+			day, err = number(d, 2)
+		case 'j':
+			yday, err = number(d, 3)
+		case 'H', 'I':
+			hour, err = number(d, 2)
+		case 'M':
+			minute, err = number(d, 2)
+		case 'S':
+			second, err = number(d, 2)
+		case 'p':
+			var i int
+			i, err = name(d, []string{"AM", "PM"}, false)
+			pm, hasPM = i == 1, true
+		case 'b':
+			month, err = name(d, monthNames, true)
+			month++
+		case 'B':
+			month, err = name(d, monthNames, false)
+			month++
+		case 'a':
+			_, err = name(d, dayNames, true)
+		case 'A':
+			_, err = name(d, dayNames, false)
+		case 'z':
+			loc, err = parseOffset(text, &pos)
+		case 'Z':
+			start := pos
+			for pos < len(text) && (text[pos] >= 'A' && text[pos] <= 'Z') {
+				pos++
+			}
+			if zone := text[start:pos]; zone == "UTC" || zone == "GMT" {
+				loc = time.UTC
+			}
+		default:
+			return time.Time{}, fmt.Errorf("unknown directive %%%c", d)
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if pos != len(text) {
+		return time.Time{}, fmt.Errorf("unexpected %q after the time", text[pos:])
+	}
+
+	if hasPM {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, fmt.Errorf("hour %d is out of range for %%I", hour)
+		}
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+	}
+	if yday > 0 {
+		month, day = 1, yday
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+	// time.Date moves dates like 31 April on to the next month.
+	if yday == 0 && (t.Day() != day || int(t.Month()) != month) || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("%q is not a valid time", text)
+	}
+	return t, nil
+}
+
+// parseOffset reads a UTC offset such as +0100, -05:30 or Z.
+func parseOffset(text string, pos *int) (*time.Location, error) {
+	rest := text[*pos:]
+	if strings.HasPrefix(rest, "Z") {
+		*pos++
+		return time.UTC, nil
+	}
+	if len(rest) < 5 || (rest[0] != '+' && rest[0] != '-') {
+		return nil, fmt.Errorf("expected an offset such as +0100 at %q", rest)
+	}
+	digits := rest[1:5]
+	n := 5
+	if rest[3] == ':' && len(rest) >= 6 {
+		digits = rest[1:3] + rest[4:6]
+		n = 6
+	}
+	hours, err1 := strconv.Atoi(digits[:2])
+	minutes, err2 := strconv.Atoi(digits[2:])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("expected an offset such as +0100 at %q", rest)
+	}
+	offset := hours*3600 + minutes*60
+	if rest[0] == '-' {
+		offset = -offset
+	}
+	*pos += n
+	return time.FixedZone(rest[:n], offset), nil
+}
+
+func openTimeModule(L *lua.LState) int {
+	module := L.NewTable()
+
+	zoneArg := func(L *lua.LState, n int) *time.Location {
+		loc, err := timeZone(L.OptString(n, "local"))
+		if err != nil {
+			L.ArgError(n, err.Error())
+		}
+		return loc
+	}
+	timeArg := func(L *lua.LState, n int) time.Time {
+		return secondsToTime(float64(L.CheckNumber(n)))
+	}
+
+	L.SetFuncs(module, map[string]lua.LGFunction{
+		"now": func(L *lua.LState) int {
+			L.Push(timeToSeconds(time.Now()))
+			return 1
+		},
+		// parse reads a time in RFC 3339 format, or YYYY-MM-DD, unless a
+		// format is given.
+		"parse": func(L *lua.LState) int {
+			text := L.CheckString(1)
+			format := L.OptString(2, "")
+			loc := zoneArg(L, 3)
+			var t time.Time
+			var err error
+			if format == "" {
+				t, err = time.Parse(time.RFC3339, text)
+				if err != nil {
+					t, err = time.ParseInLocation(time.DateOnly, text, loc)
+				}
+				if err != nil {
+					err = fmt.Errorf("%q is not an RFC 3339 time or YYYY-MM-DD date", text)
+				}
+			} else {
+				t, err = parseTime(text, format, loc)
+			}
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(timeToSeconds(t))
+			return 1
+		},
+		"format": func(L *lua.LState) int {
+			t := timeArg(L, 1).In(zoneArg(L, 3))
+			format := L.OptString(2, "")
+			if format == "" {
+				L.Push(lua.LString(t.Format(time.RFC3339)))
+				return 1
+			}
+			text, err := formatTime(t, format)
+			if err != nil {
+				L.RaiseError("%v", err)
+			}
+			L.Push(lua.LString(text))
+			return 1
+		},
+		// date splits a time into fields like os.date("*t"), in any zone.
+		"date": func(L *lua.LState) int {
+			t := timeArg(L, 1).In(zoneArg(L, 2))
+			zone, offset := t.Zone()
+			L.Push(goToLua(L, map[string]any{
+				"year":   float64(t.Year()),
+				"month":  float64(t.Month()),
+				"day":    float64(t.Day()),
+				"hour":   float64(t.Hour()),
+				"min":    float64(t.Minute()),
+				"sec":    float64(t.Second()),
+				"wday":   float64(t.Weekday() + 1),
+				"yday":   float64(t.YearDay()),
+				"zone":   zone,
+				"offset": float64(offset),
+			}))
+			return 1
+		},
+		// make builds a time from fields like os.time, in any zone. Fields
+		// out of range carry over, so day 32 of January is 1 February.
+		"make": func(L *lua.LState) int {
+			fields := L.CheckTable(1)
+			loc := zoneArg(L, 2)
+			field := func(name string, def int) int {
+				if n, ok := fields.RawGetString(name).(lua.LNumber); ok {
+					return int(n)
+				}
+				return def
+			}
+			t := time.Date(field("year", 1970), time.Month(field("month", 1)), field("day", 1),
+				field("hour", 0), field("min", 0), field("sec", 0), 0, loc)
+			L.Push(timeToSeconds(t))
+			return 1
+		},
+		// add moves a time by calendar amounts, worked out in a zone so
+		// adding a day keeps the time of day across a clock change.
+		"add": func(L *lua.LState) int {
+			t := timeArg(L, 1).In(zoneArg(L, 3))
+			amounts := L.CheckTable(2)
+			amount := func(name string) int {
+				n, _ := amounts.RawGetString(name).(lua.LNumber)
+				return int(n)
+			}
+			t = t.AddDate(amount("years"), amount("months"), amount("days"))
+			t = t.Add(time.Duration(amount("hours"))*time.Hour +
+				time.Duration(amount("minutes"))*time.Minute +
+				time.Duration(amount("seconds"))*time.Second)
+			L.Push(timeToSeconds(t))
+			return 1
+		},
+	})
+
+	L.Push(module)
+	return 1
+}
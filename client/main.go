@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -14,6 +16,40 @@ import (
 var setActive func(string)
 
 func main() {
+	schemaOut := flag.String("schema", "", "write the template JSON Schema to this file (- for stdout) and exit")
+	installFrom := flag.String("install", "", "install or upgrade a plugin from this zip file or folder and exit")
+	force := flag.Bool("force", false, "with -install, install an older version over a newer one")
+	uninstall := flag.String("uninstall", "", "uninstall the plugin with this name and exit")
+	deleteData := flag.Bool("delete-data", false, "with -uninstall, delete the plugin's data folder too")
+	flag.Parse()
+
+	if *schemaOut != "" {
+		if err := writeTemplateSchema(*schemaOut); err != nil {
+			log.Fatalf("Could not write JSON Schema: %v", err)
+		}
+		return
+	}
+	if *installFrom != "" {
+		LoadSettings()
+		if settingsLoadError != nil {
+			log.Fatalf("Could not load settings: %v", settingsLoadError)
+		}
+		if err := installPluginCLI(*installFrom, *force); err != nil {
+			log.Fatalf("Could not install plugin: %v", err)
+		}
+		return
+	}
+	if *uninstall != "" {
+		LoadSettings()
+		if settingsLoadError != nil {
+			log.Fatalf("Could not load settings: %v", settingsLoadError)
+		}
+		if err := uninstallPluginCLI(*uninstall, *deleteData); err != nil {
+			log.Fatalf("Could not uninstall plugin: %v", err)
+		}
+		return
+	}
+
 	a := app.NewWithID("Receiptify")
 	w := a.NewWindow("Receiptify")
 	w.Resize(fyne.NewSize(900, 700))
@@ -22,20 +58,34 @@ func main() {
 
 	err := ConfigureLuaAndLoadPlugins()
 	if err != nil && err.Error() != "plugin path not set" {
-		log.Fatalf("An error occurred whilst loading plugins: %v", err)
+		log.Printf("An error occurred whilst loading plugins: %v", err)
 	}
 
 	w.SetContent(mainAppContent(w))
+	showSettingsLoadError(w)
+	showPluginLoadFailures(w)
+	askPluginPermissions(w)
+	watchPlugins(w)
 	w.ShowAndRun()
 
-	if luaVm != nil && !luaVm.Dead {
-		luaVm.Close()
+	closePluginStates()
+}
+
+func writeTemplateSchema(path string) error {
+	schema, err := GenerateTemplateSchema()
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err = os.Stdout.Write(append(schema, '\n'))
+		return err
 	}
+	return os.WriteFile(path, schema, 0644)
 }
 
 func mainAppContent(w fyne.Window) fyne.CanvasObject {
 	content := container.NewStack()
-	var btnEditor, btnSettings, btnLibrary, btnCreate *widget.Button
+	var btnEditor, btnSettings, btnLibrary, btnCreate, btnPlugins *widget.Button
 	var navButtons *fyne.Container
 
 	setActive = func(active string) {
@@ -43,6 +93,7 @@ func mainAppContent(w fyne.Window) fyne.CanvasObject {
 		btnSettings.Importance = widget.MediumImportance
 		btnLibrary.Importance = widget.MediumImportance
 		btnCreate.Importance = widget.MediumImportance
+		btnPlugins.Importance = widget.MediumImportance
 
 		switch active {
 		case "editor":
@@ -57,6 +108,9 @@ func mainAppContent(w fyne.Window) fyne.CanvasObject {
 		case "create":
 			btnCreate.Importance = widget.HighImportance
 			content.Objects = []fyne.CanvasObject{CreateUI(w)}
+		case "plugins":
+			btnPlugins.Importance = widget.HighImportance
+			content.Objects = []fyne.CanvasObject{PluginsUI(w)}
 		}
 		content.Refresh()
 		navButtons.Refresh()
@@ -66,12 +120,14 @@ func mainAppContent(w fyne.Window) fyne.CanvasObject {
 	btnSettings = widget.NewButtonWithIcon("Settings", theme.SettingsIcon(), func() { setActive("settings") })
 	btnLibrary = widget.NewButtonWithIcon("Template Library", theme.FolderOpenIcon(), func() { setActive("library") })
 	btnCreate = widget.NewButtonWithIcon("Create Receipt", theme.ContentAddIcon(), func() { setActive("create") })
+	btnPlugins = widget.NewButtonWithIcon("Plugins", theme.ComputerIcon(), func() { setActive("plugins") })
 
 	navButtons = container.NewVBox(
 		MakeHeaderLabel("Receiptify"),
 		btnEditor,
 		btnCreate,
 		btnLibrary,
+		btnPlugins,
 		btnSettings,
 		layout.NewSpacer(),
 	)
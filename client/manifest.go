@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	paramTypes  = []string{"string", "number", "boolean", "date", "list", "table", "any"}
+	returnTypes = []string{"string", "number", "boolean", "date", "list", "table", "components", "any"}
+	// itemTypes are the types the items of a list argument can be declared
+	// as, and enumTypes the types which can have a list of allowed values.
+	itemTypes = []string{"string", "number", "boolean", "date"}
+	enumTypes = []string{"string", "number"}
+)
+
+func (p *ParamInfo) UnmarshalJSON(data []byte) error {
+	var typ string
+	if json.Unmarshal(data, &typ) == nil {
+		*p = ParamInfo{Type: typ}
+		return nil
+	}
+	type plain ParamInfo
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+func (r *ReturnInfo) UnmarshalJSON(data []byte) error {
+	var typ string
+	if json.Unmarshal(data, &typ) == nil {
+		*r = ReturnInfo{Type: typ}
+		return nil
+	}
+	type plain ReturnInfo
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+func (p ParamInfo) optional() bool {
+	return p.Optional || p.Default != nil
+}
+
+// label names an argument in errors, such as "argument 2 (size)".
+func (p ParamInfo) label(i int) string {
+	if p.Name != "" {
+		return fmt.Sprintf("argument %d (%s)", i+1, p.Name)
+	}
+	return fmt.Sprintf("argument %d", i+1)
+}
+
+func (f FunctionInfo) requiredParams() int {
+	required := 0
+	for i, p := range f.Params {
+		if !p.optional() {
+			required = i + 1
+		}
+	}
+	return required
+}
+
+func (f FunctionInfo) checkArgs(n int) error {
+	required := f.requiredParams()
+	if n < required || n > len(f.Params) {
+		if required == len(f.Params) {
+			return fmt.Errorf("expects %d args, got %d", len(f.Params), n)
+		}
+		return fmt.Errorf("expects %d to %d args, got %d", required, len(f.Params), n)
+	}
+	return nil
+}
+
+// toTimestamp reads a date argument, which can be a Unix timestamp, an RFC
+// 3339 time, or a date and optional time in the local time zone.
+func toTimestamp(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		text := strings.TrimSpace(v)
+		if t, err := time.Parse(time.RFC3339, text); err == nil {
+			return float64(t.Unix()), nil
+		}
+		for _, layout := range []string{time.DateOnly, time.DateTime, "2006-01-02 15:04"} {
+			if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+				return float64(t.Unix()), nil
+			}
+		}
+		return 0, fmt.Errorf("%s is not a date such as 2024-02-29", strconv.Quote(v))
+	}
+	return 0, fmt.Errorf("expected a date but found %s", describeValue(value))
+}
+
+// convert checks an argument against the param and converts it to the
+// param's type. Dates become Unix timestamps.
+func (p ParamInfo) convert(locale LocaleFormat, value any) (any, error) {
+	var converted any
+	switch p.Type {
+	case "string":
+		converted = locale.toText(value)
+	case "number":
+		n, err := locale.toNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		converted = n
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		converted = b
+	case "date":
+		seconds, err := toTimestamp(value)
+		if err != nil {
+			return nil, err
+		}
+		converted = seconds
+	case "list":
+		items, ok := value.([]any)
+		if !ok {
+			return nil, fmt.Errorf("must be a list but is %s", describeValue(value))
+		}
+		if p.Items != "" {
+			item := ParamInfo{Type: p.Items}
+			convertedItems := make([]any, len(items))
+			for i, v := range items {
+				c, err := item.convert(locale, v)
+				if err != nil {
+					return nil, fmt.Errorf("item %d: %v", i+1, err)
+				}
+				convertedItems[i] = c
+			}
+			items = convertedItems
+		}
+		converted = items
+	case "table":
+		switch value.(type) {
+		case []any, map[string]any:
+			converted = value
+		default:
+			return nil, fmt.Errorf("must be a list or table but is %s", describeValue(value))
+		}
+	case "any":
+		converted = value
+	default:
+		return nil, fmt.Errorf("unsupported param type: %s", p.Type)
+	}
+
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, converted) {
+		return nil, fmt.Errorf("must be one of %s but is %s", enumText(p.Enum), locale.toText(converted))
+	}
+	return converted, nil
+}
+
+func enumText(values []any) string {
+	texts := make([]string, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			texts[i] = strconv.Quote(s)
+		} else {
+			texts[i] = fallbackLocale.toText(v)
+		}
+	}
+	return strings.Join(texts, ", ")
+}
+
+// apply formats a value returned by a plugin as the manifest asks. Numbers
+// with a precision and dates become text.
+func (r ReturnInfo) apply(locale LocaleFormat, value any) (any, error) {
+	switch r.Type {
+	case "number":
+		if r.Precision == nil {
+			return value, nil
+		}
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("must be a number but is %s", describeValue(value))
+		}
+		text := strconv.FormatFloat(n, 'f', *r.Precision, 64)
+		return strings.Replace(text, ".", locale.DecimalSeparator, 1), nil
+	case "date":
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("must be a timestamp but is %s", describeValue(value))
+		}
+		t := secondsToTime(n)
+		if r.Format != "" {
+			return formatTime(t, r.Format)
+		}
+		return locale.FormatDate(t), nil
+	case "list":
+		switch v := value.(type) {
+		case nil:
+			return []any{}, nil
+		case []any:
+			return v, nil
+		}
+		return nil, fmt.Errorf("must be a list but is %s", describeValue(value))
+	}
+	return value, nil
+}
+
+// validateFunctions checks the functions a manifest declares make sense, so
+// mistakes show up when the plugin loads rather than when it's called.
+func validateFunctions(functions []FunctionInfo) error {
+	seen := map[string]bool{}
+	for _, f := range functions {
+		if !variableNameRegexp.MatchString(f.Name) {
+			return fmt.Errorf("function name %q must start with a letter or underscore and contain only letters, digits and underscores", f.Name)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("function %s is declared twice", f.Name)
+		}
+		seen[f.Name] = true
+		if f.Preview != "" && !variableNameRegexp.MatchString(f.Preview) {
+			return fmt.Errorf("function %s: preview %q is not a function name", f.Name, f.Preview)
+		}
+		if err := validateParams(f.Params); err != nil {
+			return fmt.Errorf("function %s: %v", f.Name, err)
+		}
+		for i, r := range f.Returns {
+			if err := validateReturn(r); err != nil {
+				return fmt.Errorf("function %s: return value %d: %v", f.Name, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateParams(params []ParamInfo) error {
+	names := map[string]bool{}
+	optional := false
+	for i, p := range params {
+		label := p.label(i)
+		if p.Name != "" {
+			if !variableNameRegexp.MatchString(p.Name) {
+				return fmt.Errorf("%s: name must start with a letter or underscore and contain only letters, digits and underscores", label)
+			}
+			if names[p.Name] {
+				return fmt.Errorf("%s: there is already an argument called %s", label, p.Name)
+			}
+			names[p.Name] = true
+		}
+		if !slices.Contains(paramTypes, p.Type) {
+			return fmt.Errorf("%s has unknown type %q", label, p.Type)
+		}
+		if p.Items != "" && (p.Type != "list" || !slices.Contains(itemTypes, p.Items)) {
+			return fmt.Errorf("%s: items can only be given for a list, as %s", label, strings.Join(itemTypes, ", "))
+		}
+		if len(p.Enum) > 0 {
+			if !slices.Contains(enumTypes, p.Type) {
+				return fmt.Errorf("%s: only %s arguments can have an enum", label, strings.Join(enumTypes, " and "))
+			}
+			for _, v := range p.Enum {
+				if converted, err := (ParamInfo{Type: p.Type}).convert(fallbackLocale, v); err != nil || converted != v {
+					return fmt.Errorf("%s: enum value %s is not a %s", label, fallbackLocale.toText(v), p.Type)
+				}
+			}
+		}
+		if p.Default != nil {
+			if _, err := p.convert(fallbackLocale, p.Default); err != nil {
+				return fmt.Errorf("%s: default %v", label, err)
+			}
+		}
+
+		if p.optional() {
+			optional = true
+		} else if optional {
+			return fmt.Errorf("%s is required, so can't come after an optional argument", label)
+		}
+	}
+	return nil
+}
+
+func validateReturn(r ReturnInfo) error {
+	if !slices.Contains(returnTypes, r.Type) {
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+	if r.Precision != nil && (r.Type != "number" || *r.Precision < 0) {
+		return fmt.Errorf("precision can only be given for a number, as 0 or more decimal places")
+	}
+	if r.Format != "" {
+		if r.Type != "date" {
+			return fmt.Errorf("format can only be given for a date")
+		}
+		if _, err := formatTime(time.Now(), r.Format); err != nil {
+			return fmt.Errorf("format: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkExports makes sure the plugin's table has every function its manifest
+// declares, once its main.lua has run.
+func checkExports(L *lua.LState, manifest *PluginManifest) error {
+	table, ok := L.GetGlobal(manifest.PluginName).(*lua.LTable)
+	if !ok {
+		return fmt.Errorf("main.lua must set the global table %s", manifest.PluginName)
+	}
+	for _, f := range manifest.Functions {
+		for _, name := range []string{f.Name, f.Preview} {
+			if name != "" && L.GetField(table, name).Type() != lua.LTFunction {
+				return fmt.Errorf("the manifest declares %s.%s but main.lua doesn't define it", manifest.PluginName, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const defaultPrinter = "(default)"
+
+// stampTemplate records that the template has just been saved by the current
+// user.
+func stampTemplate(meta *TemplateMetadata) {
+	now := time.Now()
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+	meta.Modified = now
+	if settings.UserName != "" {
+		meta.ModifiedBy = settings.UserName
+		if meta.Author == "" {
+			meta.Author = settings.UserName
+		}
+	}
+}
+
+func parseTags(text string) []string {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func printerNames() []string {
+	names := []string{defaultPrinter}
+	for _, p := range settings.Printers {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return "Unknown"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func showTemplateDetailsDialog(meta *TemplateMetadata, onSave func(), w fyne.Window) {
+	var detailsDialog *dialog.CustomDialog
+
+	descriptionEntry := widget.NewMultiLineEntry()
+	descriptionEntry.SetText(meta.Description)
+	descriptionEntry.Wrapping = fyne.TextWrapWord
+
+	tagsEntry := widget.NewEntry()
+	tagsEntry.SetPlaceHolder("Comma separated")
+	tagsEntry.SetText(strings.Join(meta.Tags, ", "))
+
+	authorEntry := widget.NewEntry()
+	authorEntry.SetText(meta.Author)
+
+	printerSelect := widget.NewSelect(printerNames(), func(s string) {})
+	if meta.Printer != "" {
+		printerSelect.SetSelected(meta.Printer)
+	} else {
+		printerSelect.SetSelected(defaultPrinter)
+	}
+
+	localeSelect := widget.NewSelect(localeCodes(), func(s string) {})
+	localeSelect.SetSelected(localeOrDefault(meta.Locale).Code)
+
+	copiesEntry := widget.NewEntry()
+	copiesEntry.SetText(strconv.Itoa(max(meta.Copies, 1)))
+
+	modified := formatTimestamp(meta.Modified)
+	if meta.ModifiedBy != "" {
+		modified += " by " + meta.ModifiedBy
+	}
+
+	form := widget.NewForm(
+		widget.NewFormItem("Description", descriptionEntry),
+		widget.NewFormItem("Tags", tagsEntry),
+		widget.NewFormItem("Author", authorEntry),
+		widget.NewFormItem("Locale", localeSelect),
+		widget.NewFormItem("Default Printer", printerSelect),
+		widget.NewFormItem("Default Copies", copiesEntry),
+		widget.NewFormItem("Created", widget.NewLabel(formatTimestamp(meta.Created))),
+		widget.NewFormItem("Modified", widget.NewLabel(modified)),
+	)
+
+	saveBtn := widget.NewButton("Save", func() {
+		copies, err := strconv.Atoi(copiesEntry.Text)
+		if err != nil || copies < 1 {
+			copies = 1
+		}
+
+		meta.Description = descriptionEntry.Text
+		meta.Tags = parseTags(tagsEntry.Text)
+		meta.Author = authorEntry.Text
+		meta.Locale = localeSelect.Selected
+		meta.Printer = ""
+		if printerSelect.Selected != defaultPrinter {
+			meta.Printer = printerSelect.Selected
+		}
+		meta.Copies = 0
+		if copies > 1 {
+			meta.Copies = copies
+		}
+
+		onSave()
+		detailsDialog.Hide()
+	})
+
+	detailsDialog = dialog.NewCustom("Template Details", "Cancel", container.NewVBox(form, saveBtn), w)
+	detailsDialog.Resize(fyne.NewSize(400, 400))
+	detailsDialog.Show()
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseError is a problem with the placeholders in a piece of text. Line and
+// Column are 1-based and count characters, not bytes.
+type ParseError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+type SegmentKind int
+
+const (
+	TextSegment SegmentKind = iota
+	PlaceholderSegment
+)
+
+// Segment is a run of literal text, or the source of a placeholder without
+// its braces. Line and Column give the position of the segment's first
+// character.
+type Segment struct {
+	Kind   SegmentKind
+	Text   string
+	Line   int
+	Column int
+}
+
+// scanner walks text a rune at a time, keeping track of the line and column.
+type scanner struct {
+	runes  []rune
+	pos    int
+	line   int
+	column int
+}
+
+func newScanner(text string, line int, column int) *scanner {
+	return &scanner{runes: []rune(text), line: line, column: column}
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.runes)
+}
+
+func (s *scanner) peek(offset int) rune {
+	if s.pos+offset >= len(s.runes) {
+		return 0
+	}
+	return s.runes[s.pos+offset]
+}
+
+func (s *scanner) hasPrefix(prefix string) bool {
+	for i, r := range []rune(prefix) {
+		if s.peek(i) != r {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *scanner) next() rune {
+	r := s.runes[s.pos]
+	s.pos++
+	if r == '\n' {
+		s.line++
+		s.column = 1
+	} else {
+		s.column++
+	}
+	return r
+}
+
+func (s *scanner) errorf(line int, column int, format string, args ...any) *ParseError {
+	return &ParseError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+// ParseTemplateText splits text into literal text and placeholders. Text
+// outside placeholders is kept exactly, apart from \{ and \} which produce a
+// literal brace, so "\{{" can be written without starting a placeholder.
+// Quoted strings inside a placeholder may contain braces.
+func ParseTemplateText(text string) ([]Segment, error) {
+	s := newScanner(text, 1, 1)
+	var segments []Segment
+	var literal strings.Builder
+	literalLine, literalColumn := 1, 1
+
+	flush := func() {
+		if literal.Len() > 0 {
+			segments = append(segments, Segment{Kind: TextSegment, Text: literal.String(), Line: literalLine, Column: literalColumn})
+			literal.Reset()
+		}
+	}
+
+	for !s.done() {
+		if literal.Len() == 0 {
+			literalLine, literalColumn = s.line, s.column
+		}
+
+		switch {
+		case s.peek(0) == '\\' && (s.peek(1) == '{' || s.peek(1) == '}'):
+			s.next()
+			literal.WriteRune(s.next())
+
+		case s.hasPrefix("{{"):
+			flush()
+			openLine, openColumn := s.line, s.column
+			s.next()
+			s.next()
+
+			segment, err := scanPlaceholder(s)
+			if err != nil {
+				return nil, err
+			}
+			if segment == nil {
+				return nil, s.errorf(openLine, openColumn, "placeholder is never closed with }}")
+			}
+			segments = append(segments, *segment)
+
+		default:
+			literal.WriteRune(s.next())
+		}
+	}
+	flush()
+
+	return segments, nil
+}
+
+// scanPlaceholder reads up to and including the closing braces of a
+// placeholder. It returns nil if the text ends first.
+func scanPlaceholder(s *scanner) (*Segment, error) {
+	segment := &Segment{Kind: PlaceholderSegment, Line: s.line, Column: s.column}
+	var source strings.Builder
+
+	for !s.done() {
+		if s.hasPrefix("}}") {
+			s.next()
+			s.next()
+			segment.Text = source.String()
+			return segment, nil
+		}
+
+		r := s.peek(0)
+		if r == '"' || r == '\'' {
+			quoteLine, quoteColumn := s.line, s.column
+			source.WriteRune(s.next())
+			closed := false
+			for !s.done() {
+				c := s.next()
+				source.WriteRune(c)
+				if c == '\\' && !s.done() {
+					source.WriteRune(s.next())
+					continue
+				}
+				if c == r {
+					closed = true
+					break
+				}
+			}
+			if !closed {
+				return nil, s.errorf(quoteLine, quoteColumn, "string is never closed with %c", r)
+			}
+			continue
+		}
+
+		source.WriteRune(s.next())
+	}
+	return nil, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	Kind   tokenKind
+	Text   string
+	Value  any
+	Line   int
+	Column int
+}
+
+func (t token) describe() string {
+	switch t.Kind {
+	case tokenEOF:
+		return "end of placeholder"
+	case tokenString:
+		return strconv.Quote(t.Text)
+	default:
+		return "'" + t.Text + "'"
+	}
+}
+
+// punctuation lists the symbols understood inside placeholders, longest
+// first so that two-character symbols win.
+var punctuation = []string{
+	"==", "!=", "<=", ">=",
+	"<", ">", "+", "-", "*", "/", "%", "~", "|", ":", ".", ",", "(", ")", "[", "]",
+}
+
+// lexPlaceholder splits the source of a placeholder into tokens.
+func lexPlaceholder(segment Segment) ([]token, error) {
+	s := newScanner(segment.Text, segment.Line, segment.Column)
+	var tokens []token
+
+	for {
+		for !s.done() && unicode.IsSpace(s.peek(0)) {
+			s.next()
+		}
+		if s.done() {
+			tokens = append(tokens, token{Kind: tokenEOF, Line: s.line, Column: s.column})
+			return tokens, nil
+		}
+
+		line, column := s.line, s.column
+		r := s.peek(0)
+
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+			var ident strings.Builder
+			for !s.done() && (s.peek(0) == '_' || unicode.IsLetter(s.peek(0)) || unicode.IsDigit(s.peek(0))) {
+				ident.WriteRune(s.next())
+			}
+			tokens = append(tokens, token{Kind: tokenIdent, Text: ident.String(), Line: line, Column: column})
+
+		case unicode.IsDigit(r):
+			var num strings.Builder
+			for !s.done() && (unicode.IsDigit(s.peek(0)) || (s.peek(0) == '.' && unicode.IsDigit(s.peek(1)))) {
+				num.WriteRune(s.next())
+			}
+			value, err := strconv.ParseFloat(num.String(), 64)
+			if err != nil {
+				return nil, s.errorf(line, column, "invalid number %s", num.String())
+			}
+			tokens = append(tokens, token{Kind: tokenNumber, Text: num.String(), Value: value, Line: line, Column: column})
+
+		case r == '"' || r == '\'':
+			value, err := lexString(s)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{Kind: tokenString, Text: value, Value: value, Line: line, Column: column})
+
+		default:
+			matched := ""
+			for _, p := range punctuation {
+				if s.hasPrefix(p) {
+					matched = p
+					break
+				}
+			}
+			if matched == "" {
+				return nil, s.errorf(line, column, "unexpected character %q", r)
+			}
+			for range []rune(matched) {
+				s.next()
+			}
+			tokens = append(tokens, token{Kind: tokenPunct, Text: matched, Line: line, Column: column})
+		}
+	}
+}
+
+func lexString(s *scanner) (string, error) {
+	line, column := s.line, s.column
+	quote := s.next()
+	var value strings.Builder
+
+	for !s.done() {
+		c := s.next()
+		if c == quote {
+			return value.String(), nil
+		}
+		if c != '\\' {
+			value.WriteRune(c)
+			continue
+		}
+		if s.done() {
+			break
+		}
+		switch e := s.next(); e {
+		case 'n':
+			value.WriteRune('\n')
+		case 't':
+			value.WriteRune('\t')
+		default:
+			value.WriteRune(e)
+		}
+	}
+	return "", s.errorf(line, column, "string is never closed with %c", quote)
+}
+
+// tokenStream is a cursor over the tokens of one placeholder.
+type tokenStream struct {
+	tokens []token
+	pos    int
+}
+
+func (ts *tokenStream) peek() token {
+	return ts.tokens[ts.pos]
+}
+
+// peekAt looks ahead without moving, returning the final EOF token if offset
+// runs past the end.
+func (ts *tokenStream) peekAt(offset int) token {
+	return ts.tokens[min(ts.pos+offset, len(ts.tokens)-1)]
+}
+
+func (ts *tokenStream) next() token {
+	t := ts.tokens[ts.pos]
+	if t.Kind != tokenEOF {
+		ts.pos++
+	}
+	return t
+}
+
+func (ts *tokenStream) isPunct(text string) bool {
+	t := ts.peek()
+	return t.Kind == tokenPunct && t.Text == text
+}
+
+func (ts *tokenStream) expectPunct(text string) (token, error) {
+	t := ts.next()
+	if t.Kind != tokenPunct || t.Text != text {
+		return t, &ParseError{Line: t.Line, Column: t.Column, Message: fmt.Sprintf("expected '%s' but found %s", text, t.describe())}
+	}
+	return t, nil
+}
+
+func (ts *tokenStream) expectIdent() (token, error) {
+	t := ts.next()
+	if t.Kind != tokenIdent {
+		return t, &ParseError{Line: t.Line, Column: t.Column, Message: fmt.Sprintf("expected a name but found %s", t.describe())}
+	}
+	return t, nil
+}
+
+// pluginCall is a call to a plugin function with its arguments already
+// evaluated. Args holds values as produced by evalExpr.
+type pluginCall struct {
+	Plugin   string
+	Function string
+	Args     []any
+	Line     int
+	Column   int
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTemplateText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Segment
+	}{
+		{"empty", "", nil},
+		{"text only", "Hello  world\n", []Segment{
+			{Kind: TextSegment, Text: "Hello  world\n", Line: 1, Column: 1},
+		}},
+		{"placeholder in a word", "Ref:{{Reference.ref(\"A\")}}!", []Segment{
+			{Kind: TextSegment, Text: "Ref:", Line: 1, Column: 1},
+			{Kind: PlaceholderSegment, Text: "Reference.ref(\"A\")", Line: 1, Column: 7},
+			{Kind: TextSegment, Text: "!", Line: 1, Column: 27},
+		}},
+		{"escaped braces", `\{{name}} and \}`, []Segment{
+			{Kind: TextSegment, Text: "{{name}} and }", Line: 1, Column: 1},
+		}},
+		{"backslash kept before other characters", `a\b`, []Segment{
+			{Kind: TextSegment, Text: `a\b`, Line: 1, Column: 1},
+		}},
+		{"braces in strings", `{{f("}}", '{{')}}`, []Segment{
+			{Kind: PlaceholderSegment, Text: `f("}}", '{{')`, Line: 1, Column: 3},
+		}},
+		{"escaped quote in string", `{{f("a\"}}")}}`, []Segment{
+			{Kind: PlaceholderSegment, Text: `f("a\"}}")`, Line: 1, Column: 3},
+		}},
+		{"positions across lines", "one\ntwo {{ x }}\n{{y}}", []Segment{
+			{Kind: TextSegment, Text: "one\ntwo ", Line: 1, Column: 1},
+			{Kind: PlaceholderSegment, Text: " x ", Line: 2, Column: 7},
+			{Kind: TextSegment, Text: "\n", Line: 2, Column: 12},
+			{Kind: PlaceholderSegment, Text: "y", Line: 3, Column: 3},
+		}},
+		{"columns count characters", "£€{{x}}", []Segment{
+			{Kind: TextSegment, Text: "£€", Line: 1, Column: 1},
+			{Kind: PlaceholderSegment, Text: "x", Line: 1, Column: 5},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTemplateText(tt.text)
+			if err != nil {
+				t.Fatalf("ParseTemplateText(%q) error: %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTemplateText(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplateTextErrors(t *testing.T) {
+	tests := []struct {
+		text    string
+		line    int
+		column  int
+		message string
+	}{
+		{"Hello {{name", 1, 7, "placeholder is never closed with }}"},
+		{"a\nb {{name}", 2, 3, "placeholder is never closed with }}"},
+		{"{{x}} {{", 1, 7, "placeholder is never closed with }}"},
+		{`{{f("abc)}}`, 1, 5, "string is never closed with \""},
+		{"x\n  {{f('a}}", 2, 7, "string is never closed with '"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseTemplateText(tt.text)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParseTemplateText(%q) error = %v, want a ParseError", tt.text, err)
+			continue
+		}
+		if parseErr.Line != tt.line || parseErr.Column != tt.column || parseErr.Message != tt.message {
+			t.Errorf("ParseTemplateText(%q) error = %v, want line %d, column %d: %s", tt.text, err, tt.line, tt.column, tt.message)
+		}
+	}
+}
+
+func TestLexPlaceholderPositions(t *testing.T) {
+	segments, err := ParseTemplateText("x\n  {{ a.b(1.5, \"s\") }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := lexPlaceholder(segments[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type pos struct {
+		text   string
+		line   int
+		column int
+	}
+	want := []pos{{"a", 2, 6}, {".", 2, 7}, {"b", 2, 8}, {"(", 2, 9}, {"1.5", 2, 10}, {",", 2, 13}, {"s", 2, 15}, {")", 2, 18}, {"", 2, 20}}
+	var got []pos
+	for _, tok := range tokens {
+		got = append(got, pos{tok.Text, tok.Line, tok.Column})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens = %v, want %v", got, want)
+	}
+}
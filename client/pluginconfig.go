@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var configTypes = []string{"string", "number", "boolean"}
+
+// pluginSecrets holds the secret settings of each plugin by plugin name. They
+// are kept out of settings.json so that it can be shared.
+var pluginSecrets map[string]map[string]any
+
+func secretsFile() string {
+	return filepath.Join(filepath.Dir(settingsFile), "secrets.json")
+}
+
+func loadPluginSecrets() error {
+	pluginSecrets = map[string]map[string]any{}
+	data, err := os.ReadFile(secretsFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &pluginSecrets); err != nil {
+		return fmt.Errorf("secrets file is corrupt: %v", err)
+	}
+	return nil
+}
+
+func savePluginSecrets() error {
+	data, err := json.MarshalIndent(pluginSecrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(secretsFile(), data, 0600)
+}
+
+// checkConfigValue makes sure a setting's value has the type the manifest
+// declares.
+func checkConfigValue(field ConfigField, value any) error {
+	ok := false
+	switch field.Type {
+	case "string":
+		_, ok = value.(string)
+	case "number":
+		_, ok = value.(float64)
+	case "boolean":
+		_, ok = value.(bool)
+	}
+	if !ok {
+		return fmt.Errorf("%s must be a %s but is %s", field.Key, field.Type, describeValue(value))
+	}
+	return nil
+}
+
+func validateConfigFields(fields []ConfigField) error {
+	seen := map[string]bool{}
+	for _, field := range fields {
+		if !variableNameRegexp.MatchString(field.Key) {
+			return fmt.Errorf("config key %q must start with a letter or underscore and contain only letters, digits and underscores", field.Key)
+		}
+		if seen[field.Key] {
+			return fmt.Errorf("config key %s is declared twice", field.Key)
+		}
+		seen[field.Key] = true
+		if !slices.Contains(configTypes, field.Type) {
+			return fmt.Errorf("config key %s has unknown type %q", field.Key, field.Type)
+		}
+		if field.Default != nil {
+			if err := checkConfigValue(field, field.Default); err != nil {
+				return fmt.Errorf("default: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// pluginConfig gives the value of each of a plugin's settings, falling back
+// to the default in the manifest. Settings which are neither set nor have a
+// default are left out.
+func pluginConfig(manifest *PluginManifest) map[string]any {
+	values := map[string]any{}
+	for _, field := range manifest.Config {
+		stored := settings.PluginConfig[manifest.PluginName]
+		if field.Secret {
+			stored = pluginSecrets[manifest.PluginName]
+		}
+		if value, ok := stored[field.Key]; ok && checkConfigValue(field, value) == nil {
+			values[field.Key] = value
+		} else if field.Default != nil {
+			values[field.Key] = field.Default
+		}
+	}
+	return values
+}
+
+// setPluginConfig stores a plugin's settings, putting secrets in the secrets
+// file.
+func setPluginConfig(manifest *PluginManifest, values map[string]any) error {
+	public := map[string]any{}
+	secret := map[string]any{}
+	for _, field := range manifest.Config {
+		value, ok := values[field.Key]
+		if !ok {
+			continue
+		}
+		if field.Secret {
+			secret[field.Key] = value
+		} else {
+			public[field.Key] = value
+		}
+	}
+
+	if settings.PluginConfig == nil {
+		settings.PluginConfig = map[string]map[string]any{}
+	}
+	settings.PluginConfig[manifest.PluginName] = public
+	if pluginSecrets == nil {
+		pluginSecrets = map[string]map[string]any{}
+	}
+	pluginSecrets[manifest.PluginName] = secret
+	return savePluginSecrets()
+}
+
+// readOnlyTable wraps a table so Lua code can read it but not change it.
+func readOnlyTable(L *lua.LState, table *lua.LTable) *lua.LTable {
+	proxy := L.NewTable()
+	meta := L.NewTable()
+	meta.RawSetString("__index", table)
+	meta.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("config is read-only")
+		return 0
+	}))
+	meta.RawSetString("__metatable", lua.LFalse)
+	L.SetMetatable(proxy, meta)
+	return proxy
+}
+
+// setPluginConfigGlobal gives a plugin its settings as NAME_CONFIG, before its
+// main.lua runs.
+func setPluginConfigGlobal(L *lua.LState, manifest *PluginManifest) {
+	table := goToLua(L, pluginConfig(manifest)).(*lua.LTable)
+	L.SetGlobal(strings.ToUpper(manifest.PluginName)+"_CONFIG", readOnlyTable(L, table))
+}
+
+func configLabel(field ConfigField) string {
+	if field.Label != "" {
+		return field.Label
+	}
+	return field.Key
+}
+
+func showPluginConfigsDialog(w fyne.Window) {
+	listContainer := container.NewVBox()
+
+	names := make([]string, 0, len(manifests))
+	for name, manifest := range manifests {
+		if len(manifest.Config) > 0 {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		manifest := manifests[name]
+		btn := widget.NewButton(name+" "+manifest.Version, func() {
+			showPluginConfigDialog(manifest, w)
+		})
+		btn.Alignment = widget.ButtonAlignLeading
+		listContainer.Add(btn)
+	}
+	if len(names) == 0 {
+		listContainer.Add(widget.NewLabel("No loaded plugins have settings."))
+	}
+
+	scroll := container.NewVScroll(listContainer)
+	scroll.SetMinSize(fyne.NewSize(300, 5*40))
+	dialog.ShowCustom("Plugin Settings", "Close", scroll, w)
+}
+
+func showPluginConfigDialog(manifest *PluginManifest, w fyne.Window) {
+	var editDialog *dialog.CustomDialog
+
+	current := pluginConfig(manifest)
+	form := widget.NewForm()
+	read := map[string]func() (any, error){}
+
+	for _, field := range manifest.Config {
+		var item *widget.FormItem
+		switch field.Type {
+		case "boolean":
+			check := widget.NewCheck("", nil)
+			check.SetChecked(current[field.Key] == true)
+			item = widget.NewFormItem(configLabel(field), check)
+			read[field.Key] = func() (any, error) { return check.Checked, nil }
+		default:
+			entry := widget.NewEntry()
+			if field.Secret {
+				entry = widget.NewPasswordEntry()
+			}
+			switch value := current[field.Key].(type) {
+			case string:
+				entry.SetText(value)
+			case float64:
+				entry.SetText(strconv.FormatFloat(value, 'f', -1, 64))
+			}
+			item = widget.NewFormItem(configLabel(field), entry)
+			if field.Type == "number" {
+				read[field.Key] = func() (any, error) {
+					if strings.TrimSpace(entry.Text) == "" {
+						return nil, nil
+					}
+					n, err := strconv.ParseFloat(strings.TrimSpace(entry.Text), 64)
+					if err != nil {
+						return nil, fmt.Errorf("%s must be a number", configLabel(field))
+					}
+					return n, nil
+				}
+			} else {
+				read[field.Key] = func() (any, error) { return entry.Text, nil }
+			}
+		}
+		item.HintText = field.Description
+		form.AppendItem(item)
+	}
+
+	saveBtn := widget.NewButton("Save", func() {
+		values := map[string]any{}
+		for _, field := range manifest.Config {
+			value, err := read[field.Key]()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if value != nil {
+				values[field.Key] = value
+			}
+		}
+		if err := setPluginConfig(manifest, values); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		SaveSettings(true, w)
+		editDialog.Hide()
+	})
+
+	editDialog = dialog.NewCustom(manifest.PluginName+" Settings", "Cancel", container.NewVBox(form, saveBtn), w)
+	editDialog.Resize(fyne.NewSize(400, 300))
+	editDialog.Show()
+}
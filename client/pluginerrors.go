@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// The phases of loading a plugin, for saying where it went wrong.
+const (
+	phaseManifest = "reading manifest"
+	phaseSetup    = "setting up"
+	phaseCompile  = "compiling"
+	phaseRun      = "running"
+	phaseExports  = "checking functions in"
+)
+
+// pluginLoadError says why a plugin failed to load and where. File is relative
+// to the plugin's folder, and Line is 0 if it isn't known.
+type pluginLoadError struct {
+	Phase string
+	File  string
+	Line  int
+	Err   error
+}
+
+func (e *pluginLoadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.location(), e.Err)
+}
+
+func (e *pluginLoadError) Unwrap() error {
+	return e.Err
+}
+
+// location is where loading failed, such as "compiling main.lua line 3".
+func (e *pluginLoadError) location() string {
+	location := e.Phase
+	if e.File != "" {
+		location += " " + e.File
+	}
+	if e.Line > 0 {
+		location += " line " + strconv.Itoa(e.Line)
+	}
+	return location
+}
+
+// manifestLoadError finds the line of a mistake in a manifest from the offset
+// the JSON decoder gives.
+func manifestLoadError(data []byte, err error) *pluginLoadError {
+	loadErr := &pluginLoadError{Phase: phaseManifest, File: "manifest.json", Err: err}
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return loadErr
+	}
+	offset = min(offset, int64(len(data)))
+	loadErr.Line = strings.Count(string(data[:offset]), "\n") + 1
+	return loadErr
+}
+
+var (
+	// luaSyntaxError matches gopher-lua's syntax errors, such as
+	// "main.lua line:3(column:5) near 'end':   syntax error", wherever they
+	// appear, as they can be wrapped by require.
+	luaSyntaxError = regexp.MustCompile(`([^\s:]+\.lua) (?:line:(\d+)\(column:\d+\) near ('.*?')|at EOF):\s+(.*)`)
+	// luaRuntimeError matches errors raised while running, such as
+	// "main.lua:3: attempt to index a nil value".
+	luaRuntimeError = regexp.MustCompile(`^([^\s:]+\.lua):(\d+):\s*(.*)`)
+)
+
+// luaLoadError works out which file and line a Lua error came from. file is
+// used if the error doesn't say.
+func luaLoadError(phase string, file string, err error) *pluginLoadError {
+	message := err.Error()
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		// Leave out the stack trace.
+		message = apiErr.Object.String()
+	}
+	message = strings.TrimSpace(message)
+
+	loadErr := &pluginLoadError{Phase: phase, File: file}
+	if m := luaSyntaxError.FindStringSubmatch(message); m != nil {
+		loadErr.Phase = phaseCompile
+		loadErr.File = m[1]
+		loadErr.Line, _ = strconv.Atoi(m[2])
+		message = m[4]
+		if m[3] != "" {
+			message += " near " + m[3]
+		} else {
+			message += " at the end of the file"
+		}
+	} else if m := luaRuntimeError.FindStringSubmatch(message); m != nil {
+		loadErr.File = m[1]
+		loadErr.Line, _ = strconv.Atoi(m[2])
+		message = m[3]
+	}
+	loadErr.Err = errors.New(message)
+	return loadErr
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// pluginEntry is a plugin Receiptify found, whether or not it loaded.
+// Manifest is nil if the manifest couldn't be read.
+type pluginEntry struct {
+	Folder   string
+	Path     string
+	Embedded bool
+	DataPath string
+	Manifest *PluginManifest
+	Disabled bool
+	// Err is why the plugin failed to load, and CallErr is the error from
+	// the last call to one of its functions which failed.
+	Err     *pluginLoadError
+	CallErr error
+}
+
+// pluginEntries lists every plugin found by the last load, in the order they
+// were loaded. It is guarded by pluginsMu.
+var pluginEntries []*pluginEntry
+
+func pluginEntrySource(embedded bool, folder string, path string) string {
+	if embedded {
+		return "embedded:" + folder
+	}
+	return path
+}
+
+func (e *pluginEntry) source() string {
+	return pluginEntrySource(e.Embedded, e.Folder, e.Path)
+}
+
+func (e *pluginEntry) name() string {
+	if e.Manifest != nil && e.Manifest.PluginName != "" {
+		return e.Manifest.PluginName
+	}
+	return e.Folder
+}
+
+func (e *pluginEntry) loaded() bool {
+	return e.Manifest != nil && e.Manifest.state != nil && manifests[e.Manifest.PluginName] == e.Manifest
+}
+
+func (e *pluginEntry) status() string {
+	switch {
+	case e.Err != nil:
+		return "Failed to load"
+	case e.Disabled:
+		return "Disabled"
+	case e.loaded():
+		return "Loaded"
+	}
+	return "Not loaded"
+}
+
+// addPluginEntry records a plugin, replacing any earlier record of the same
+// plugin.
+func addPluginEntry(entry *pluginEntry) {
+	removePluginEntry(entry.source())
+	pluginEntries = append(pluginEntries, entry)
+}
+
+func removePluginEntry(source string) {
+	pluginEntries = slices.DeleteFunc(pluginEntries, func(e *pluginEntry) bool { return e.source() == source })
+}
+
+// recordPluginCallError remembers the last error from calling a plugin, to
+// show in the plugin manager.
+func recordPluginCallError(pluginName string, err error) {
+	for _, entry := range pluginEntries {
+		if entry.loaded() && entry.Manifest.PluginName == pluginName {
+			entry.CallErr = err
+		}
+	}
+}
+
+func pluginDisabled(name string) bool {
+	return slices.Contains(settings.DisabledPlugins, name)
+}
+
+// unloadPlugin closes a plugin's Lua state and forgets its functions.
+func unloadPlugin(entry *pluginEntry) {
+	if entry.loaded() {
+		closePluginState(entry.Manifest)
+		delete(manifests, entry.Manifest.PluginName)
+	}
+}
+
+// reloadPlugin loads a plugin again from its files, leaving the other plugins
+// alone.
+func reloadPlugin(entry *pluginEntry) error {
+	if !entry.Embedded {
+		return reloadPluginFromDisk(entry.Path)
+	}
+
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	unloadPlugin(entry)
+	return loadPluginFromFS(embeddedPlugins, "plugins", entry.Folder)
+}
+
+func setPluginEnabled(entry *pluginEntry, enabled bool, w fyne.Window) error {
+	name := entry.name()
+	settings.DisabledPlugins = slices.DeleteFunc(settings.DisabledPlugins, func(n string) bool { return n == name })
+	if !enabled {
+		settings.DisabledPlugins = append(settings.DisabledPlugins, name)
+	}
+	SaveSettings(false, w)
+	return reloadPlugin(entry)
+}
+
+// showPluginLoadFailures tells the user which plugins failed to load, so a
+// broken plugin doesn't go unnoticed.
+func showPluginLoadFailures(w fyne.Window) {
+	var failed []string
+	for _, entry := range pluginEntries {
+		if entry.Err != nil {
+			failed = append(failed, entry.name()+": "+entry.Err.Error())
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	slices.Sort(failed)
+
+	message := fmt.Sprintf("%d plugins failed to load:\n\n%s", len(failed), strings.Join(failed, "\n"))
+	if len(failed) == 1 {
+		message = "A plugin failed to load:\n\n" + failed[0]
+	}
+	failures := dialog.NewConfirm("Plugins Failed to Load", message, func(show bool) {
+		if show {
+			setActive("plugins")
+		}
+	}, w)
+	failures.SetConfirmText("Show Plugins")
+	failures.SetDismissText("Close")
+	failures.Show()
+}
+
+func PluginsUI(w fyne.Window) fyne.CanvasObject {
+	listContainer := container.NewVBox()
+
+	var refreshList func()
+	refreshList = func() {
+		listContainer.Objects = nil
+
+		// Take the status of each plugin while holding the lock, as a
+		// receipt being filled in can record call errors meanwhile.
+		type card struct {
+			entry   *pluginEntry
+			status  string
+			callErr error
+		}
+		pluginsMu.Lock()
+		cards := make([]card, len(pluginEntries))
+		for i, entry := range pluginEntries {
+			cards[i] = card{entry, entry.status(), entry.CallErr}
+		}
+		pluginsMu.Unlock()
+		slices.SortFunc(cards, func(a, b card) int {
+			return strings.Compare(strings.ToLower(a.entry.name()), strings.ToLower(b.entry.name()))
+		})
+
+		for _, c := range cards {
+			listContainer.Add(pluginCard(c.entry, c.status, c.callErr, refreshList, w))
+		}
+		if len(cards) == 0 {
+			listContainer.Add(widget.NewLabel("No plugins found. Check the plugin path in Settings."))
+		}
+		listContainer.Refresh()
+	}
+
+	reloadAllBtn := widget.NewButtonWithIcon("Reload All", theme.ViewRefreshIcon(), func() {
+		err := ConfigureLuaAndLoadPlugins()
+		if err != nil && err.Error() != "plugin path not set" {
+			dialog.ShowError(err, w)
+		}
+		askPluginPermissions(w)
+		refreshList()
+	})
+
+	installBtn := widget.NewButtonWithIcon("Install Plugin", theme.ContentAddIcon(), func() {
+		showInstallPluginDialog(refreshList, w)
+	})
+
+	refreshList()
+
+	return container.NewBorder(
+		container.NewVBox(MakeHeaderLabel("Plugins"), container.NewHBox(installBtn, reloadAllBtn)),
+		nil, nil, nil,
+		container.NewVScroll(listContainer),
+	)
+}
+
+func pluginCard(entry *pluginEntry, status string, callErr error, refreshList func(), w fyne.Window) fyne.CanvasObject {
+	title := entry.name()
+	if entry.Manifest != nil && entry.Manifest.Version != "" {
+		title += " " + entry.Manifest.Version
+	}
+	source := "Embedded in Receiptify"
+	if !entry.Embedded {
+		source = "From " + entry.Path
+	}
+
+	details := container.NewVBox(widget.NewLabel("Status: " + status))
+	addError := func(label string, err error) {
+		errLabel := widget.NewLabel(label + ": " + err.Error())
+		errLabel.Wrapping = fyne.TextWrapWord
+		errLabel.Importance = widget.DangerImportance
+		details.Add(errLabel)
+	}
+	if entry.Err != nil {
+		addError("Failed while "+entry.Err.location(), entry.Err.Err)
+	}
+	if callErr != nil {
+		addError("Last error", callErr)
+	}
+	if entry.Manifest != nil {
+		for _, f := range entry.Manifest.Functions {
+			details.Add(functionRow(pluginSignature(entry.Manifest.PluginName, f), pluginFunctionDoc(f)))
+		}
+	}
+
+	enableBtn := widget.NewButton("Disable", func() {
+		if err := setPluginEnabled(entry, false, w); err != nil {
+			dialog.ShowError(err, w)
+		}
+		refreshList()
+	})
+	if entry.Disabled {
+		enableBtn.SetText("Enable")
+		enableBtn.OnTapped = func() {
+			if err := setPluginEnabled(entry, true, w); err != nil {
+				dialog.ShowError(err, w)
+			}
+			askPluginPermissions(w)
+			refreshList()
+		}
+	}
+	// Plugins are disabled by name, which comes from the manifest.
+	if entry.Manifest == nil {
+		enableBtn.Disable()
+	}
+
+	reloadBtn := widget.NewButtonWithIcon("Reload", theme.ViewRefreshIcon(), func() {
+		if err := reloadPlugin(entry); err != nil {
+			dialog.ShowError(err, w)
+		}
+		askPluginPermissions(w)
+		refreshList()
+	})
+
+	dataBtn := widget.NewButtonWithIcon("Open Data Folder", theme.FolderOpenIcon(), func() {
+		u, err := url.Parse(storage.NewFileURI(entry.DataPath).String())
+		if err == nil {
+			err = fyne.CurrentApp().OpenURL(u)
+		}
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("could not open %s: %v", entry.DataPath, err), w)
+		}
+	})
+
+	buttons := container.NewHBox(enableBtn, reloadBtn, dataBtn)
+	// Embedded plugins are part of Receiptify, so can only be disabled.
+	if !entry.Embedded {
+		buttons.Add(widget.NewButtonWithIcon("Uninstall", theme.DeleteIcon(), func() {
+			showUninstallPluginDialog(entry, refreshList, w)
+		}))
+	}
+	details.Add(buttons)
+	return widget.NewCard(title, source, details)
+}
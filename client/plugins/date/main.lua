@@ -1,5 +1,12 @@
 Date = {}
 
+local time = require("time")
+
 function Date.currentDate()
-    return os.date("%d/%m/%Y")
+    return Locale.date()
+end
+
+-- inDays gives the date a number of days from today, such as a due date.
+function Date.inDays(days)
+    return time.add(time.now(), { days = days })
 end
@@ -1,35 +1,34 @@
 Reference = {}
 
-local state_file = REFERENCE_DATA_FOLDER .. "/refs.ini"
-local refs = {}
+local function counter_name(target)
+    return "Reference " .. target
+end
 
-local function load_refs()
+-- References used to be kept in refs.ini. Move them into Receiptify's
+-- counters the first time this version runs.
+local function migrate_refs()
+    local state_file = REFERENCE_DATA_FOLDER .. "/refs.ini"
     local f = io.open(state_file, "r")
     if not f then return end
     for line in f:lines() do
         local key, val = line:match("^(.-)=(%d+)$")
         if key and val then
-            refs[key] = tonumber(val)
+            receiptify.createCounter(counter_name(key), tonumber(val))
         end
     end
     f:close()
+    os.rename(state_file, state_file .. ".migrated")
 end
 
-local function save_refs()
-    local f = io.open(state_file, "w")
-    if not f then return end
-    for k, v in pairs(refs) do
-        f:write(k .. "=" .. v .. "\n")
-    end
-    f:close()
+function Reference.ref(target)
+    return target .. "-" .. receiptify.nextCounter(counter_name(target))
 end
 
-function Reference.ref(target)
-    refs[target] = (refs[target] or 0) + 1
-    save_refs()
-    return target .. "-" .. refs[target]
+-- Used instead of ref when previewing, so no reference is used up.
+function Reference.peek(target)
+    return target .. "-" .. receiptify.peekCounter(counter_name(target))
 end
 
-load_refs()
+migrate_refs()
 
 return Reference
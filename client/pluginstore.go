@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pluginStoreMu guards the plugins' store files within this process, and
+// their lock files guard them against other copies of Receiptify.
+var pluginStoreMu sync.Mutex
+
+// pluginStoreFile is where the values a plugin stores through
+// receiptify.store are kept, in its data folder so each plugin has its own.
+func pluginStoreFile(dataPath string) string {
+	return filepath.Join(dataPath, "store.json")
+}
+
+func loadPluginStore(dataPath string) (map[string]any, error) {
+	values := map[string]any{}
+	data, err := os.ReadFile(pluginStoreFile(dataPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("store file is corrupt: %v", err)
+	}
+	return values, nil
+}
+
+// withPluginStore runs fn on a plugin's stored values while holding the lock,
+// and saves them afterwards if fn reports a change.
+func withPluginStore(dataPath string, fn func(values map[string]any) (bool, error)) error {
+	pluginStoreMu.Lock()
+	defer pluginStoreMu.Unlock()
+
+	path := pluginStoreFile(dataPath)
+	return withFileLock(path, func() error {
+		values, err := loadPluginStore(dataPath)
+		if err != nil {
+			return err
+		}
+		changed, err := fn(values)
+		if err != nil || !changed {
+			return err
+		}
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data, 0644)
+	})
+}
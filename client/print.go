@@ -8,6 +8,10 @@ import (
 	"net/http"
 )
 
+// printableWidth is the print server's canvas width minus its margins, in
+// pixels.
+const printableWidth = 472
+
 func SendToPrinter(export []Component, url string) error {
 	j, err := json.MarshalIndent(export, "", "  ")
 	if err != nil {
@@ -30,3 +34,37 @@ func SendToPrinter(export []Component, url string) error {
 	}
 	return nil
 }
+
+// PrintCopies sends the receipt to the print server once per copy.
+func PrintCopies(export []Component, url string, copies int) error {
+	if copies < 1 {
+		copies = 1
+	}
+	for i := 0; i < copies; i++ {
+		if err := SendToPrinter(export, url); err != nil {
+			if copies > 1 {
+				return fmt.Errorf("copy %d of %d: %v", i+1, copies, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func findPrinter(name string) (PrinterProfile, bool) {
+	for _, p := range settings.Printers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return PrinterProfile{}, false
+}
+
+// printerURL returns the print server for a printer profile, falling back to
+// the default print server if the profile is empty or no longer exists.
+func printerURL(profile string) string {
+	if p, ok := findPrinter(profile); ok {
+		return p.PrintServerURL
+	}
+	return settings.PrintServerURL
+}
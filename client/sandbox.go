@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// PluginPermissions are the capabilities a plugin needs beyond its own data
+// folder. They are declared in the manifest and only granted once the user
+// has approved them.
+type PluginPermissions struct {
+	// Filesystem lists folders and files the plugin can read and write.
+	Filesystem []string `json:"filesystem,omitempty"`
+	// Env lists environment variables the plugin can read.
+	Env []string `json:"env,omitempty"`
+}
+
+func (p PluginPermissions) empty() bool {
+	return len(p.Filesystem) == 0 && len(p.Env) == 0
+}
+
+func (p PluginPermissions) describe() string {
+	var lines []string
+	for _, path := range p.Filesystem {
+		lines = append(lines, "Read and write "+path)
+	}
+	for _, name := range p.Env {
+		lines = append(lines, "Read the environment variable "+name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// grantedPermissions are the permissions a plugin declares which the user has
+// approved.
+func grantedPermissions(manifest *PluginManifest) PluginPermissions {
+	approved := settings.ApprovedPermissions[manifest.PluginName]
+	var granted PluginPermissions
+	for _, path := range manifest.Permissions.Filesystem {
+		if slices.Contains(approved.Filesystem, path) {
+			granted.Filesystem = append(granted.Filesystem, path)
+		}
+	}
+	for _, name := range manifest.Permissions.Env {
+		if slices.Contains(approved.Env, name) {
+			granted.Env = append(granted.Env, name)
+		}
+	}
+	return granted
+}
+
+// unapprovedPermissions are the permissions a plugin declares which the user
+// hasn't approved yet.
+func unapprovedPermissions(manifest *PluginManifest) PluginPermissions {
+	approved := settings.ApprovedPermissions[manifest.PluginName]
+	var pending PluginPermissions
+	for _, path := range manifest.Permissions.Filesystem {
+		if !slices.Contains(approved.Filesystem, path) {
+			pending.Filesystem = append(pending.Filesystem, path)
+		}
+	}
+	for _, name := range manifest.Permissions.Env {
+		if !slices.Contains(approved.Env, name) {
+			pending.Env = append(pending.Env, name)
+		}
+	}
+	return pending
+}
+
+// sandbox decides which files a plugin can open. Paths are resolved through
+// symlinks, so a link in the data folder can't point outside it.
+type sandbox struct {
+	dataPath string
+	roots    []string
+}
+
+func newSandbox(dataPath string, granted PluginPermissions) *sandbox {
+	s := &sandbox{dataPath: dataPath}
+	for _, root := range append([]string{dataPath}, granted.Filesystem...) {
+		if resolved, err := resolvePath(expandHome(root)); err == nil {
+			s.roots = append(s.roots, resolved)
+		}
+	}
+	return s
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// resolvePath makes a path absolute and follows any symlinks in the parts of
+// it which exist.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	parent := filepath.Dir(abs)
+	if parent == abs {
+		return abs, nil
+	}
+	dir, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+// allow checks a path a plugin wants to open. Relative paths are taken to be
+// in the data folder.
+func (s *sandbox) allow(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.dataPath, path)
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.roots {
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("permission denied: the plugin isn't allowed to use %s", path)
+}
+
+// sandboxedLibs are the standard libraries plugins get. debug is left out, as
+// it can get around the rest of the sandbox.
+var sandboxedLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.LoadLibName, lua.OpenPackage},
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.IoLibName, lua.OpenIo},
+	{lua.OsLibName, lua.OpenOs},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+	{lua.CoroutineLibName, lua.OpenCoroutine},
+}
+
+// newSandboxedState creates a Lua state which can only open files the
+// sandbox allows, can't run programs, and can only load Lua code from the
+// plugin's own files.
+func newSandboxedState(options lua.Options, files fs.FS, s *sandbox, env []string) *lua.LState {
+	options.SkipOpenLibs = true
+	L := lua.NewState(options)
+	for _, lib := range sandboxedLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// checkPaths wraps a library function so the arguments at the given
+	// positions must be paths the sandbox allows.
+	checkPaths := func(table *lua.LTable, name string, positions ...int) {
+		original := L.GetField(table, name)
+		table.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+			for _, i := range positions {
+				if L.GetTop() < i || L.Get(i).Type() != lua.LTString {
+					continue
+				}
+				path, err := s.allow(L.ToString(i))
+				if err != nil {
+					L.RaiseError("%v", err)
+				}
+				L.Replace(i, lua.LString(path))
+			}
+			top := L.GetTop()
+			L.Insert(original, 1)
+			L.Call(top, lua.MultRet)
+			return L.GetTop()
+		}))
+	}
+
+	ioTable := L.GetGlobal(lua.IoLibName).(*lua.LTable)
+	checkPaths(ioTable, "open", 1)
+	checkPaths(ioTable, "lines", 1)
+	checkPaths(ioTable, "input", 1)
+	checkPaths(ioTable, "output", 1)
+	ioTable.RawSetString("popen", lua.LNil)
+
+	osTable := L.GetGlobal(lua.OsLibName).(*lua.LTable)
+	checkPaths(osTable, "remove", 1)
+	checkPaths(osTable, "rename", 1, 2)
+	for _, name := range []string{"execute", "exit", "setenv", "setlocale", "tmpname"} {
+		osTable.RawSetString(name, lua.LNil)
+	}
+	osTable.RawSetString("getenv", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		if value, ok := os.LookupEnv(name); ok && slices.Contains(env, name) {
+			L.Push(lua.LString(value))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	}))
+
+	loadPluginFile := func(L *lua.LState, name string) *lua.LFunction {
+		data, err := fs.ReadFile(files, filepath.ToSlash(name))
+		if err != nil {
+			L.RaiseError("cannot open %s: only the plugin's own files can be loaded", name)
+		}
+		fn, err := L.Load(strings.NewReader(string(data)), name)
+		if err != nil {
+			L.RaiseError("%v", err)
+		}
+		return fn
+	}
+	L.SetGlobal("loadfile", L.NewFunction(func(L *lua.LState) int {
+		L.Push(loadPluginFile(L, L.CheckString(1)))
+		return 1
+	}))
+	L.SetGlobal("dofile", L.NewFunction(func(L *lua.LState) int {
+		top := L.GetTop()
+		L.Push(loadPluginFile(L, L.CheckString(1)))
+		L.Call(0, lua.MultRet)
+		return L.GetTop() - top
+	}))
+
+	return L
+}
+
+// deniedPermissions remembers the plugins whose permissions the user turned
+// down, so they aren't asked again until Receiptify restarts.
+var deniedPermissions = map[string]bool{}
+
+// askPluginPermissions asks the user to approve the permissions of each
+// loaded plugin which declares permissions they haven't approved yet. The
+// plugins are reloaded if any are approved.
+func askPluginPermissions(w fyne.Window) {
+	var pending []*PluginManifest
+	for _, manifest := range manifests {
+		if !deniedPermissions[manifest.PluginName] && !unapprovedPermissions(manifest).empty() {
+			pending = append(pending, manifest)
+		}
+	}
+	slices.SortFunc(pending, func(a, b *PluginManifest) int { return strings.Compare(a.PluginName, b.PluginName) })
+
+	approvedAny := false
+	var askNext func(i int)
+	askNext = func(i int) {
+		if i == len(pending) {
+			if approvedAny {
+				SaveSettings(true, w)
+			}
+			return
+		}
+		manifest := pending[i]
+		message := fmt.Sprintf("The plugin %s %s wants to:\n\n%s\n\nAllow this?",
+			manifest.PluginName, manifest.Version, unapprovedPermissions(manifest).describe())
+		dialog.ShowConfirm("Plugin Permissions", message, func(allow bool) {
+			if allow {
+				if settings.ApprovedPermissions == nil {
+					settings.ApprovedPermissions = map[string]PluginPermissions{}
+				}
+				settings.ApprovedPermissions[manifest.PluginName] = manifest.Permissions
+				approvedAny = true
+			} else {
+				deniedPermissions[manifest.PluginName] = true
+			}
+			askNext(i + 1)
+		}, w)
+	}
+	askNext(0)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CurrentSchemaVersion is written to every template and settings file. Bump it
+// and append to templateMigrations whenever the JSON shape changes.
+const CurrentSchemaVersion = 2
+
+const templateSchemaID = "https://github.com/ElementalMP4/Receiptify/client/template.schema.json"
+
+type migration func(doc map[string]any) error
+
+// templateMigrations[i] upgrades a template from version i to version i+1.
+var templateMigrations = []migration{
+	migrateTemplateV0,
+	migrateTemplateV1,
+}
+
+// settingsMigrations[i] upgrades the settings file from version i to version
+// i+1. Templates in the library are migrated separately, as each one carries
+// its own version.
+var settingsMigrations = []migration{
+	migrateSettingsV0,
+	noMigration,
+}
+
+// Version 0 templates predate versioning. Hand-written files often used a
+// number for font_size, which the client can't decode.
+func migrateTemplateV0(doc map[string]any) error {
+	if layout, ok := doc["layout"].([]any); ok {
+		for _, c := range layout {
+			if comp, ok := c.(map[string]any); ok {
+				stringifyFontSize(comp)
+			}
+		}
+	}
+	return nil
+}
+
+// Version 1 components had no stable ID.
+func migrateTemplateV1(doc map[string]any) error {
+	if layout, ok := doc["layout"].([]any); ok {
+		for _, c := range layout {
+			if comp, ok := c.(map[string]any); ok {
+				if id, _ := comp["id"].(string); id == "" {
+					comp["id"] = newComponentID()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func noMigration(doc map[string]any) error {
+	return nil
+}
+
+func migrateSettingsV0(doc map[string]any) error {
+	if styles, ok := doc["styles"].([]any); ok {
+		for _, s := range styles {
+			if style, ok := s.(map[string]any); ok {
+				stringifyFontSize(style)
+			}
+		}
+	}
+	return nil
+}
+
+func stringifyFontSize(doc map[string]any) {
+	if size, ok := doc["font_size"].(float64); ok {
+		doc["font_size"] = strconv.Itoa(int(size))
+	}
+}
+
+func schemaVersionOf(doc map[string]any) (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	version, ok := raw.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid schema_version: %v", raw)
+	}
+	return int(version), nil
+}
+
+func runMigrations(doc map[string]any, migrations []migration, kind string) error {
+	version, err := schemaVersionOf(doc)
+	if err != nil {
+		return err
+	}
+	if version > CurrentSchemaVersion {
+		return fmt.Errorf("%s uses schema version %d, but this version of Receiptify only supports up to %d", kind, version, CurrentSchemaVersion)
+	}
+
+	for v := version; v < CurrentSchemaVersion; v++ {
+		if err := migrations[v](doc); err != nil {
+			return fmt.Errorf("error migrating %s from schema version %d: %v", kind, v, err)
+		}
+	}
+	doc["schema_version"] = CurrentSchemaVersion
+	return nil
+}
+
+func MigrateTemplate(doc map[string]any) error {
+	return runMigrations(doc, templateMigrations, "template")
+}
+
+// DecodeTemplate reads a template of any supported schema version and upgrades
+// it to the current one.
+func DecodeTemplate(r io.Reader) (Template, error) {
+	var doc map[string]any
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return Template{}, err
+	}
+	if err := MigrateTemplate(doc); err != nil {
+		return Template{}, err
+	}
+
+	var tmpl Template
+	if err := remarshal(doc, &tmpl); err != nil {
+		return Template{}, fmt.Errorf("error decoding template: %v", err)
+	}
+	tmpl.Layout = ensureComponentIDs(tmpl.Layout)
+	for i := range tmpl.Variants {
+		tmpl.Variants[i].Layout = ensureComponentIDs(tmpl.Variants[i].Layout)
+	}
+	return tmpl, nil
+}
+
+// MigrateSettings upgrades raw settings JSON, including every template in the
+// library, to the current schema version.
+func MigrateSettings(data []byte) ([]byte, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := runMigrations(doc, settingsMigrations, "settings"); err != nil {
+		return nil, err
+	}
+
+	if library, ok := doc["library"].([]any); ok {
+		for i, t := range library {
+			tmpl, ok := t.(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := MigrateTemplate(tmpl); err != nil {
+				return nil, fmt.Errorf("library template %d: %v", i, err)
+			}
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+func remarshal(in any, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// schemaEnums lists the allowed values for named string types.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(ComponentType("")): func() []string {
+		values := make([]string, len(componentTypes))
+		for i, t := range componentTypes {
+			values[i] = string(t)
+		}
+		return values
+	}(),
+}
+
+// schemaFieldExtras adds constraints to individual fields, keyed by
+// "TypeName.json_name", that can't be inferred from the Go type.
+var schemaFieldExtras = map[string]map[string]any{
+	"Component.id":            {"pattern": "^[0-9a-f]{16}$"},
+	"TemplateMetadata.copies": {"minimum": 0},
+	"TemplateMetadata.locale": {"enum": localeCodes()},
+	"TemplateVariant.locale":  {"enum": localeCodes()},
+	"Component.font_size":     {"pattern": "^([0-9]+|fit)$"},
+	"NamedStyle.font_size":    {"pattern": "^([0-9]+|fit)$"},
+	"Component.align":         {"enum": []string{"left", "center", "right"}},
+	"NamedStyle.align":        {"enum": []string{"left", "center", "right"}},
+	"Component.overrides": {"items": map[string]any{
+		"enum": []string{StyleBold, StyleItalic, StyleUnderline, StyleFontSize, StyleAlign},
+	}},
+	"Template.schema_version": {"const": CurrentSchemaVersion},
+	"TemplateVariable.name":   {"pattern": variableNamePattern},
+	"Component.repeat_as":     {"pattern": variableNamePattern},
+}
+
+// GenerateTemplateSchema builds a JSON Schema document describing the
+// template format from the Go types, so it can't drift from what the client
+// actually reads and writes.
+func GenerateTemplateSchema() ([]byte, error) {
+	defs := map[string]any{}
+	root := schemaForType(reflect.TypeOf(Template{}), defs)
+
+	doc := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     templateSchemaID,
+		"title":   "Receiptify Template",
+		"$ref":    root["$ref"],
+		"$defs":   defs,
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func schemaForType(t reflect.Type, defs map[string]any) map[string]any {
+	if values, ok := schemaEnums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaForType(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaForType(t.Elem(), defs)}
+	case reflect.Pointer:
+		return schemaForType(t.Elem(), defs)
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, done := defs[t.Name()]; done {
+			return ref
+		}
+		// Reserve the name first so self-referencing types terminate.
+		defs[t.Name()] = nil
+		defs[t.Name()] = schemaForStruct(t, defs)
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+func schemaForStruct(t reflect.Type, defs map[string]any) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		// Untagged embedded structs are flattened by encoding/json.
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			embedded := schemaForStruct(field.Type, defs)
+			for name, prop := range embedded["properties"].(map[string]any) {
+				properties[name] = prop
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := schemaForType(field.Type, defs)
+		for k, v := range schemaFieldExtras[t.Name()+"."+name] {
+			if k == "items" {
+				items := map[string]any{}
+				for ik, iv := range prop["items"].(map[string]any) {
+					items[ik] = iv
+				}
+				for ik, iv := range v.(map[string]any) {
+					items[ik] = iv
+				}
+				prop["items"] = items
+				continue
+			}
+			prop[k] = v
+		}
+		properties[name] = prop
+
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
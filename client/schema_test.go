@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeTemplateMigrations(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"version 0", `{"name": "Old", "layout": [{"type": "text", "content": "Hi", "font_size": 14}]}`},
+		{"version 1", `{"schema_version": 1, "name": "Old", "layout": [{"type": "text", "content": "Hi", "font_size": "14"}]}`},
+		{"version 2", `{"schema_version": 2, "name": "Old", "layout": [{"id": "keep", "type": "text", "content": "Hi", "font_size": "14"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := DecodeTemplate(strings.NewReader(tt.json))
+			if err != nil {
+				t.Fatalf("DecodeTemplate error: %v", err)
+			}
+			if tmpl.SchemaVersion != CurrentSchemaVersion {
+				t.Errorf("schema_version = %d, want %d", tmpl.SchemaVersion, CurrentSchemaVersion)
+			}
+			if len(tmpl.Layout) != 1 {
+				t.Fatalf("layout has %d components, want 1", len(tmpl.Layout))
+			}
+			c := tmpl.Layout[0]
+			if c.FontSize != "14" || c.Content != "Hi" {
+				t.Errorf("component = %+v, want font_size 14 and content Hi", c)
+			}
+			if c.ID == "" {
+				t.Errorf("component has no ID")
+			}
+		})
+	}
+
+	tmpl, err := DecodeTemplate(strings.NewReader(tests[2].json))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.Layout[0].ID != "keep" {
+		t.Errorf("ID = %q, want the existing ID to be kept", tmpl.Layout[0].ID)
+	}
+}
+
+func TestDecodeTemplateRejects(t *testing.T) {
+	newer := CurrentSchemaVersion + 1
+	tests := []struct {
+		json string
+		want string
+	}{
+		{fmt.Sprintf(`{"schema_version": %d, "layout": []}`, newer),
+			fmt.Sprintf("template uses schema version %d, but this version of Receiptify only supports up to %d", newer, CurrentSchemaVersion)},
+		{`{"schema_version": -1, "layout": []}`, "invalid schema_version: -1"},
+		{`{"schema_version": 1.5, "layout": []}`, "invalid schema_version: 1.5"},
+		{`{"schema_version": "1", "layout": []}`, "invalid schema_version: 1"},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeTemplate(strings.NewReader(tt.json))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("DecodeTemplate(%s) error = %v, want %q", tt.json, err, tt.want)
+		}
+	}
+}
+
+func TestMigrateSettings(t *testing.T) {
+	data := `{
+		"print_server_url": "http://printer",
+		"styles": [{"name": "Title", "font_size": 32}],
+		"library": [
+			{"name": "A", "layout": [{"type": "text", "font_size": 12}]},
+			{"schema_version": 2, "name": "B", "layout": [{"id": "b1", "type": "divider"}]}
+		],
+		"unknown_field": "kept"
+	}`
+	migrated, err := MigrateSettings([]byte(data))
+	if err != nil {
+		t.Fatalf("MigrateSettings error: %v", err)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(migrated, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["schema_version"] != float64(CurrentSchemaVersion) {
+		t.Errorf("schema_version = %v, want %d", doc["schema_version"], CurrentSchemaVersion)
+	}
+	if doc["unknown_field"] != "kept" {
+		t.Errorf("unknown_field = %v, want it kept", doc["unknown_field"])
+	}
+
+	var s AppSettings
+	if err := json.Unmarshal(migrated, &s); err != nil {
+		t.Fatalf("migrated settings don't decode: %v", err)
+	}
+	if s.Styles[0].FontSize != "32" {
+		t.Errorf("style font_size = %q, want 32", s.Styles[0].FontSize)
+	}
+	a := s.Library[0]
+	if a.SchemaVersion != CurrentSchemaVersion || a.Layout[0].FontSize != "12" || a.Layout[0].ID == "" {
+		t.Errorf("template A = %+v, want it migrated", a)
+	}
+	if b := s.Library[1]; b.Layout[0].ID != "b1" {
+		t.Errorf("template B ID = %q, want b1", b.Layout[0].ID)
+	}
+}
+
+func TestMigrateSettingsRejectsNewer(t *testing.T) {
+	newer := CurrentSchemaVersion + 1
+	tests := []struct {
+		json string
+		want string
+	}{
+		{fmt.Sprintf(`{"schema_version": %d}`, newer), fmt.Sprintf("settings uses schema version %d", newer)},
+		{fmt.Sprintf(`{"library": [{"schema_version": %d}]}`, newer), fmt.Sprintf("library template 0: template uses schema version %d", newer)},
+		{`{"schema_version": `, "unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		_, err := MigrateSettings([]byte(tt.json))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("MigrateSettings(%s) error = %v, want it to contain %q", tt.json, err, tt.want)
+		}
+	}
+}
+
+func TestLoadSettingsLeavesNewerFileAlone(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	path := filepath.Join(dir, "settings.json")
+	data := []byte(`{"schema_version": 99, "print_server_url": "http://printer", "future": true}`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		settings = AppSettings{}
+		settingsLoadError = nil
+	})
+
+	LoadSettings()
+	if settingsLoadError == nil {
+		t.Fatal("settingsLoadError is nil, want an error for a newer file")
+	}
+	if settings.PrintServerURL != "" {
+		t.Errorf("settings were loaded from a file which failed to migrate")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(data) {
+		t.Errorf("settings file was changed to %s", after)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -10,11 +12,18 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
 var settingsFile string
 var settings AppSettings
+
+// settingsLoadError is set when the settings file couldn't be loaded, such as
+// when a newer version of Receiptify wrote it. Settings aren't saved while it
+// is set, so the file isn't overwritten with what little was understood.
+var settingsLoadError error
 var testPrint = []Component{
 	{
 		Type:     TextComponent,
@@ -66,7 +75,8 @@ func ensureConfigFileExists(path string) error {
 		pluginDir := filepath.Join(baseDir, "plugins")
 
 		emptySettings := AppSettings{
-			PluginPath: pluginDir,
+			SchemaVersion: CurrentSchemaVersion,
+			PluginPath:    pluginDir,
 		}
 
 		data, err := json.MarshalIndent(emptySettings, "", "  ")
@@ -83,12 +93,38 @@ func LoadSettings() {
 	settingsFile = getDefaultConfigPath()
 	_ = ensureConfigFileExists(settingsFile)
 	data, err := os.ReadFile(settingsFile)
-	if err == nil {
-		json.Unmarshal(data, &settings)
+	if err != nil {
+		settingsLoadError = err
+		log.Printf("Could not read settings: %v", err)
+		return
+	}
+
+	migrated, err := MigrateSettings(data)
+	if err != nil {
+		settingsLoadError = fmt.Errorf("could not load %s: %v", settingsFile, err)
+		log.Printf("Could not load settings: %v", err)
+		return
+	}
+	json.Unmarshal(migrated, &settings)
+
+	if err := loadPluginSecrets(); err != nil {
+		log.Printf("Could not load plugin secrets: %v", err)
+	}
+
+	for i := range settings.Library {
+		settings.Library[i].Layout = ensureComponentIDs(settings.Library[i].Layout)
+		for j := range settings.Library[i].Variants {
+			settings.Library[i].Variants[j].Layout = ensureComponentIDs(settings.Library[i].Variants[j].Layout)
+		}
 	}
 }
 
 func SaveSettings(reloadLua bool, w fyne.Window) {
+	if settingsLoadError != nil {
+		dialog.ShowError(fmt.Errorf("settings can't be saved because they couldn't be loaded: %v", settingsLoadError), w)
+		return
+	}
+	settings.SchemaVersion = CurrentSchemaVersion
 	data, _ := json.MarshalIndent(settings, "", "  ")
 	os.WriteFile(settingsFile, data, 0644)
 
@@ -97,7 +133,18 @@ func SaveSettings(reloadLua bool, w fyne.Window) {
 		if err != nil && err.Error() != "plugin path not set" {
 			dialog.ShowError(err, w)
 		}
+		askPluginPermissions(w)
+		watchPlugins(w)
+	}
+}
+
+// showSettingsLoadError tells the user their settings file wasn't loaded, and
+// so won't be saved over.
+func showSettingsLoadError(w fyne.Window) {
+	if settingsLoadError == nil {
+		return
 	}
+	dialog.ShowError(fmt.Errorf("%v\n\nReceiptify is running with empty settings, and won't save any changes to them until this is fixed", settingsLoadError), w)
 }
 
 func SettingsUI(w fyne.Window) fyne.CanvasObject {
@@ -107,9 +154,14 @@ func SettingsUI(w fyne.Window) fyne.CanvasObject {
 	pluginPathEntry := widget.NewEntry()
 	pluginPathEntry.SetText(settings.PluginPath)
 
+	userNameEntry := widget.NewEntry()
+	userNameEntry.SetPlaceHolder("Recorded on templates you save")
+	userNameEntry.SetText(settings.UserName)
+
 	saveBtn := widget.NewButton("Save", func() {
 		settings.PrintServerURL = urlEntry.Text
 		settings.PluginPath = pluginPathEntry.Text
+		settings.UserName = userNameEntry.Text
 		SaveSettings(true, w)
 		dialog.ShowInformation("Saved", "Settings saved!", w)
 	})
@@ -124,13 +176,141 @@ func SettingsUI(w fyne.Window) fyne.CanvasObject {
 		}
 	})
 
+	exportSchemaBtn := widget.NewButton("Export Template JSON Schema", func() {
+		fd := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			schema, err := GenerateTemplateSchema()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if _, err := writer.Write(schema); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			dialog.ShowInformation("Success", "JSON Schema exported successfully.", w)
+		}, w)
+		fd.SetFilter(storage.NewExtensionFileFilter([]string{".json"}))
+		fd.SetFileName("template.schema.json")
+		fd.Show()
+	})
+
+	printersBtn := widget.NewButton("Edit Printer Profiles", func() {
+		showPrintersDialog(w)
+	})
+
+	globalStylesBtn := widget.NewButton("Edit Global Styles", func() {
+		showStylesDialog("Global Styles", &settings.Styles, func() {
+			SaveSettings(false, w)
+		}, renameGlobalStyle, w)
+	})
+
+	countersBtn := widget.NewButton("Edit Counters", func() {
+		showCountersDialog(w)
+	})
+
+	pluginConfigBtn := widget.NewButton("Configure Plugins", func() {
+		showPluginConfigsDialog(w)
+	})
+
 	return container.NewVBox(
 		MakeHeaderLabel("Settings"),
 		widget.NewForm(
 			widget.NewFormItem("Print Server URL", urlEntry),
+			widget.NewFormItem("Printers", printersBtn),
 			widget.NewFormItem("Plugin Path", pluginPathEntry),
+			widget.NewFormItem("Plugin Settings", pluginConfigBtn),
+			widget.NewFormItem("Your Name", userNameEntry),
+			widget.NewFormItem("Styles", globalStylesBtn),
+			widget.NewFormItem("Counters", countersBtn),
+			widget.NewFormItem("Schema", exportSchemaBtn),
 			widget.NewFormItem("Test", testPrinterBtn),
 		),
 		saveBtn,
 	)
 }
+
+func showPrintersDialog(w fyne.Window) {
+	listContainer := container.NewVBox()
+
+	var refreshList func()
+	refreshList = func() {
+		listContainer.Objects = nil
+		for i, p := range settings.Printers {
+			idx := i
+			nameBtn := widget.NewButton(p.Name+" ("+p.PrintServerURL+")", func() {
+				showEditPrinterDialog(settings.Printers[idx], func(updated PrinterProfile) {
+					settings.Printers[idx] = updated
+					SaveSettings(false, w)
+					refreshList()
+				}, w)
+			})
+			nameBtn.Alignment = widget.ButtonAlignLeading
+
+			deleteBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Delete Printer", "Templates using this printer will print to the default print server. Delete it?", func(confirm bool) {
+					if confirm {
+						settings.Printers = append(settings.Printers[:idx], settings.Printers[idx+1:]...)
+						SaveSettings(false, w)
+						refreshList()
+					}
+				}, w)
+			})
+
+			listContainer.Add(container.NewBorder(nil, nil, nil, deleteBtn, nameBtn))
+		}
+		if len(settings.Printers) == 0 {
+			listContainer.Add(widget.NewLabel("No printer profiles defined."))
+		}
+		listContainer.Refresh()
+	}
+
+	addBtn := widget.NewButtonWithIcon("Add Printer", theme.ContentAddIcon(), func() {
+		showEditPrinterDialog(PrinterProfile{}, func(created PrinterProfile) {
+			settings.Printers = append(settings.Printers, created)
+			SaveSettings(false, w)
+			refreshList()
+		}, w)
+	})
+
+	refreshList()
+
+	scroll := container.NewVScroll(listContainer)
+	scroll.SetMinSize(fyne.NewSize(300, 5*40))
+	dialog.ShowCustom("Printer Profiles", "Close", container.NewBorder(nil, addBtn, nil, nil, scroll), w)
+}
+
+func showEditPrinterDialog(p PrinterProfile, onSave func(PrinterProfile), w fyne.Window) {
+	var editDialog *dialog.CustomDialog
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(p.Name)
+
+	urlEntry := widget.NewEntry()
+	urlEntry.SetText(p.PrintServerURL)
+
+	saveBtn := widget.NewButton("Save", func() {
+		if nameEntry.Text == "" || nameEntry.Text == defaultPrinter {
+			dialog.ShowInformation("Invalid Name", "Please enter a printer name.", w)
+			return
+		}
+		onSave(PrinterProfile{
+			Name:           nameEntry.Text,
+			PrintServerURL: urlEntry.Text,
+		})
+		editDialog.Hide()
+	})
+
+	form := widget.NewForm(
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Print Server URL", urlEntry),
+	)
+
+	editDialog = dialog.NewCustom("Edit Printer", "Cancel", container.NewVBox(form, saveBtn), w)
+	editDialog.Resize(fyne.NewSize(350, 200))
+	editDialog.Show()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	_ "time/tzdata" // so timezones work on systems without a zoneinfo database
+)
+
+// stdNamespace holds the functions built into Receiptify. Plugins can't use
+// the name, so a plugin can never replace them.
+const stdNamespace = "std"
+
+// stdFunction is a built-in function. Params gives the type of each argument
+// ("string", "number" or "any"), and the ones after Required are optional.
+type stdFunction struct {
+	Params      []string
+	ParamNames  []string
+	Required    int
+	Returns     string
+	Description string
+	Call        func(ctx context.Context, args []any) (any, error)
+}
+
+func (f stdFunction) checkArgs(n int) error {
+	if n < f.Required || n > len(f.Params) {
+		if f.Required == len(f.Params) {
+			return fmt.Errorf("expects %d args, got %d", len(f.Params), n)
+		}
+		return fmt.Errorf("expects %d to %d args, got %d", f.Required, len(f.Params), n)
+	}
+	return nil
+}
+
+// signature describes how to call the function, with optional arguments in
+// brackets.
+func (f stdFunction) signature(name string) string {
+	params := make([]string, len(f.ParamNames))
+	for i, p := range f.ParamNames {
+		if i >= f.Required {
+			p = "[" + p + "]"
+		}
+		params[i] = p
+	}
+	return fmt.Sprintf("%s.%s(%s)", stdNamespace, name, strings.Join(params, ", "))
+}
+
+var stdFunctions = map[string]stdFunction{
+	"now": {
+		Params: []string{"string", "string"}, ParamNames: []string{"format", "timezone"},
+		Returns:     "string",
+		Description: `The current date and time, written with a Go layout such as "2006-01-02 15:04", in the locale's format if none is given`,
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return formatStdTime(localeFrom(ctx), time.Now(), args)
+		},
+	},
+	"date": {
+		Params: []string{"number", "string", "string"}, ParamNames: []string{"timestamp", "format", "timezone"},
+		Required:    1,
+		Returns:     "string",
+		Description: "A Unix timestamp written as a date and time, like std.now",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return formatStdTime(localeFrom(ctx), time.Unix(int64(args[0].(float64)), 0), args[1:])
+		},
+	},
+	"timestamp": {
+		Returns:     "number",
+		Description: "The current time as a Unix timestamp",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return float64(time.Now().Unix()), nil
+		},
+	},
+	"next": {
+		Params: []string{"string"}, ParamNames: []string{"counter"},
+		Required:    1,
+		Returns:     "string",
+		Description: "Hands out the next number from a counter, which is created if it doesn't exist. Previews show the next number without using it up",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return NextCounter(args[0].(string), previewing(ctx))
+		},
+	},
+	"peek": {
+		Params: []string{"string"}, ParamNames: []string{"counter"},
+		Required:    1,
+		Returns:     "string",
+		Description: "The number a counter will hand out next, without using it up",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return PeekCounter(args[0].(string))
+		},
+	},
+	"uuid": {
+		Returns:     "string",
+		Description: "A random UUID",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+		},
+	},
+	"random": {
+		Params: []string{"number", "number"}, ParamNames: []string{"min", "max"},
+		Required:    2,
+		Returns:     "number",
+		Description: "A random whole number from min to max",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			lo, hi := math.Ceil(args[0].(float64)), math.Floor(args[1].(float64))
+			if hi < lo {
+				return nil, fmt.Errorf("max is less than min")
+			}
+			b := make([]byte, 8)
+			rand.Read(b)
+			var n uint64
+			for _, x := range b {
+				n = n<<8 | uint64(x)
+			}
+			return lo + float64(n%uint64(hi-lo+1)), nil
+		},
+	},
+	"pad": {
+		Params: []string{"any", "number", "string"}, ParamNames: []string{"text", "width", "align"},
+		Required:    2,
+		Returns:     "string",
+		Description: `Pads text with spaces to a width; align is "left" (the default), "right" or "center"`,
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return filterPad(localeFrom(ctx), args[0], args[1:])
+		},
+	},
+	"round": {
+		Params: []string{"number", "number"}, ParamNames: []string{"n", "decimals"},
+		Required:    1,
+		Returns:     "number",
+		Description: "Rounds a number to a number of decimal places",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return filters["round"].Apply(localeFrom(ctx), args[0], args[1:])
+		},
+	},
+	"floor": {
+		Params: []string{"number"}, ParamNames: []string{"n"},
+		Required:    1,
+		Returns:     "number",
+		Description: "Rounds a number down",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return math.Floor(args[0].(float64)), nil
+		},
+	},
+	"ceil": {
+		Params: []string{"number"}, ParamNames: []string{"n"},
+		Required:    1,
+		Returns:     "number",
+		Description: "Rounds a number up",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return math.Ceil(args[0].(float64)), nil
+		},
+	},
+	"abs": {
+		Params: []string{"number"}, ParamNames: []string{"n"},
+		Required:    1,
+		Returns:     "number",
+		Description: "A number without its sign",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return math.Abs(args[0].(float64)), nil
+		},
+	},
+	"min": {
+		Params: []string{"number", "number"}, ParamNames: []string{"a", "b"},
+		Required:    2,
+		Returns:     "number",
+		Description: "The smaller of two numbers",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return math.Min(args[0].(float64), args[1].(float64)), nil
+		},
+	},
+	"max": {
+		Params: []string{"number", "number"}, ParamNames: []string{"a", "b"},
+		Required:    2,
+		Returns:     "number",
+		Description: "The larger of two numbers",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return math.Max(args[0].(float64), args[1].(float64)), nil
+		},
+	},
+	"number": {
+		Params: []string{"number", "number"}, ParamNames: []string{"n", "decimals"},
+		Required:    1,
+		Returns:     "string",
+		Description: "A number with the locale's separators",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return filters["number"].Apply(localeFrom(ctx), args[0], args[1:])
+		},
+	},
+	"currency": {
+		Params: []string{"number", "string"}, ParamNames: []string{"amount", "code"},
+		Required:    1,
+		Returns:     "string",
+		Description: "An amount of money in the locale's currency, or in the currency code given",
+		Call: func(ctx context.Context, args []any) (any, error) {
+			return filters["currency"].Apply(localeFrom(ctx), args[0], args[1:])
+		},
+	},
+}
+
+func formatStdTime(locale LocaleFormat, t time.Time, args []any) (string, error) {
+	if len(args) > 1 && args[1] != "" {
+		loc, err := time.LoadLocation(args[1].(string))
+		if err != nil {
+			return "", fmt.Errorf("unknown timezone %q", args[1])
+		}
+		t = t.In(loc)
+	}
+	if len(args) > 0 && args[0] != "" {
+		return t.Format(args[0].(string)), nil
+	}
+	return locale.FormatDate(t) + " " + locale.FormatTime(t), nil
+}
+
+// callStd runs a built-in function, converting the arguments to the types
+// it expects.
+func callStd(ctx context.Context, name string, args []any) (any, error) {
+	f, ok := stdFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("function %s not found in %s", name, stdNamespace)
+	}
+	if err := f.checkArgs(len(args)); err != nil {
+		return nil, err
+	}
+
+	locale := localeFrom(ctx)
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch f.Params[i] {
+		case "number":
+			n, err := locale.toNumber(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.ParamNames[i], err)
+			}
+			converted[i] = n
+		case "string":
+			converted[i] = locale.toText(arg)
+		default:
+			converted[i] = arg
+		}
+	}
+	return f.Call(ctx, converted)
+}
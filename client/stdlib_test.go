@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStdFunctionCheckArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"timestamp", 0, ""},
+		{"timestamp", 1, "expects 0 args, got 1"},
+		{"floor", 0, "expects 1 args, got 0"},
+		{"floor", 1, ""},
+		{"min", 1, "expects 2 args, got 1"},
+		{"date", 0, "expects 1 to 3 args, got 0"},
+		{"date", 3, ""},
+		{"date", 4, "expects 1 to 3 args, got 4"},
+		{"now", 0, ""},
+		{"now", 2, ""},
+		{"now", 3, "expects 0 to 2 args, got 3"},
+	}
+
+	for _, tt := range tests {
+		err := stdFunctions[tt.name].checkArgs(tt.n)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s with %d args: error = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestStdFunctionsAreConsistent(t *testing.T) {
+	for name, f := range stdFunctions {
+		if len(f.ParamNames) != len(f.Params) {
+			t.Errorf("%s has %d params but %d param names", name, len(f.Params), len(f.ParamNames))
+		}
+		if f.Required > len(f.Params) {
+			t.Errorf("%s requires %d of %d params", name, f.Required, len(f.Params))
+		}
+	}
+}
+
+func TestCallStd(t *testing.T) {
+	tests := []struct {
+		name string
+		args []any
+		want any
+	}{
+		{"floor", []any{"2.7"}, 2.0},
+		{"ceil", []any{2.1}, 3.0},
+		{"abs", []any{"-4"}, 4.0},
+		{"max", []any{"3", 10.0}, 10.0},
+		{"round", []any{"2.345", "2"}, 2.35},
+		{"number", []any{"1234.5", 2.0}, "1,234.50"},
+		{"currency", []any{"3", "eur"}, "€3.00"},
+		{"pad", []any{7.0, "3", "right"}, "  7"},
+		{"random", []any{5.0, 5.0}, 5.0},
+		{"date", []any{"0", "2006-01-02 15:04", "UTC"}, "1970-01-01 00:00"},
+	}
+
+	for _, tt := range tests {
+		got, err := callStd(context.Background(), tt.name, tt.args)
+		if err != nil {
+			t.Errorf("std.%s%v error: %v", tt.name, tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("std.%s%v = %#v, want %#v", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCallStdErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []any
+		want string
+	}{
+		{"missing", nil, "function missing not found in std"},
+		{"floor", []any{"abc"}, `n: "abc" is not a number`},
+		{"floor", []any{nil}, "n: expected a number but found an empty value"},
+		{"min", []any{1.0, []any{}}, "b: expected a number but found a list"},
+		{"round", []any{1.0, true}, "decimals: expected a number but found a boolean"},
+		{"random", []any{5.0, 1.0}, "max is less than min"},
+		{"now", []any{"", "Mars/Olympus"}, `unknown timezone "Mars/Olympus"`},
+		{"abs", nil, "expects 1 args, got 0"},
+	}
+
+	for _, tt := range tests {
+		_, err := callStd(context.Background(), tt.name, tt.args)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("std.%s%v error = %v, want %q", tt.name, tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestStdTimestamp(t *testing.T) {
+	before := float64(time.Now().Unix())
+	got, err := callStd(context.Background(), "timestamp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := got.(float64); !ok || n < before || n > before+5 {
+		t.Errorf("std.timestamp() = %v, want about %v", got, before)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	StyleBold      = "bold"
+	StyleItalic    = "italic"
+	StyleUnderline = "underline"
+	StyleFontSize  = "font_size"
+	StyleAlign     = "align"
+)
+
+const noStyle = "(none)"
+
+// findStyle looks up a style by name, preferring the template's own styles
+// over the global ones in settings.
+func findStyle(name string, local []NamedStyle) (NamedStyle, bool) {
+	for _, s := range local {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	for _, s := range settings.Styles {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return NamedStyle{}, false
+}
+
+func availableStyleNames(local []NamedStyle) []string {
+	names := []string{noStyle}
+	for _, s := range local {
+		if !slices.Contains(names, s.Name) {
+			names = append(names, s.Name)
+		}
+	}
+	for _, s := range settings.Styles {
+		if !slices.Contains(names, s.Name) {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// ResolveStyle returns the component with every property it doesn't override
+// taken from its named style. Components without a style, or whose style no
+// longer exists, are returned unchanged.
+func ResolveStyle(c Component, local []NamedStyle) Component {
+	if c.Style == "" {
+		return c
+	}
+	style, ok := findStyle(c.Style, local)
+	if !ok {
+		return c
+	}
+
+	if !slices.Contains(c.Overrides, StyleBold) {
+		c.Bold = style.Bold
+	}
+	if !slices.Contains(c.Overrides, StyleItalic) {
+		c.Italic = style.Italic
+	}
+	if !slices.Contains(c.Overrides, StyleUnderline) {
+		c.Underline = style.Underline
+	}
+	if !slices.Contains(c.Overrides, StyleFontSize) {
+		c.FontSize = style.FontSize
+	}
+	if !slices.Contains(c.Overrides, StyleAlign) {
+		c.Align = style.Align
+	}
+	return c
+}
+
+// styleOverrides lists the properties of c which differ from the style, and
+// so should keep their own value when the style changes.
+func styleOverrides(c Component, style NamedStyle) []string {
+	var overrides []string
+	if c.Bold != style.Bold {
+		overrides = append(overrides, StyleBold)
+	}
+	if c.Italic != style.Italic {
+		overrides = append(overrides, StyleItalic)
+	}
+	if c.Underline != style.Underline {
+		overrides = append(overrides, StyleUnderline)
+	}
+	if c.FontSize != style.FontSize {
+		overrides = append(overrides, StyleFontSize)
+	}
+	if c.Align != style.Align {
+		overrides = append(overrides, StyleAlign)
+	}
+	return overrides
+}
+
+// renameStyleReferences points every component in layout which uses the style
+// old at the style renamed to new instead.
+func renameStyleReferences(layout []Component, old string, new string) {
+	for i := range layout {
+		if layout[i].Style == old {
+			layout[i].Style = new
+		}
+	}
+}
+
+func renameTemplateStyle(tmpl *Template, old string, new string) {
+	renameStyleReferences(tmpl.Layout, old, new)
+	for i := range tmpl.Variants {
+		renameStyleReferences(tmpl.Variants[i].Layout, old, new)
+	}
+}
+
+// renameGlobalStyle updates the templates in the library and the editor which
+// use a global style, except those with a style of their own by that name.
+func renameGlobalStyle(old string, new string) {
+	for i := range settings.Library {
+		if styleIndex(settings.Library[i].Styles, old) < 0 {
+			renameTemplateStyle(&settings.Library[i], old, new)
+		}
+	}
+	if styleIndex(currentTemplateStyles, old) < 0 {
+		renameEditorStyle(old, new)
+	}
+}
+
+func styleIndex(styles []NamedStyle, name string) int {
+	return slices.IndexFunc(styles, func(s NamedStyle) bool { return s.Name == name })
+}
+
+// showStylesDialog edits a list of styles. onRename is called when a style is
+// renamed so the components using it can be updated.
+func showStylesDialog(title string, styles *[]NamedStyle, onChange func(), onRename func(old string, new string), w fyne.Window) {
+	listContainer := container.NewVBox()
+
+	var refreshList func()
+	refreshList = func() {
+		listContainer.Objects = nil
+		for i, s := range *styles {
+			idx := i
+			nameBtn := widget.NewButton(s.Name, func() {
+				showEditStyleDialog((*styles)[idx], func(updated NamedStyle) error {
+					old := (*styles)[idx].Name
+					if updated.Name != old && styleIndex(*styles, updated.Name) >= 0 {
+						return fmt.Errorf("there is already a style called %s", updated.Name)
+					}
+					(*styles)[idx] = updated
+					if updated.Name != old {
+						onRename(old, updated.Name)
+					}
+					refreshList()
+					onChange()
+					return nil
+				}, w)
+			})
+			nameBtn.Alignment = widget.ButtonAlignLeading
+
+			deleteBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				dialog.ShowConfirm("Delete Style", "Components using this style will lose the properties it gave them, and keep only the ones they set themselves. Delete it?", func(confirm bool) {
+					if confirm {
+						*styles = append((*styles)[:idx], (*styles)[idx+1:]...)
+						refreshList()
+						onChange()
+					}
+				}, w)
+			})
+
+			listContainer.Add(container.NewBorder(nil, nil, nil, deleteBtn, nameBtn))
+		}
+		if len(*styles) == 0 {
+			listContainer.Add(widget.NewLabel("No styles defined."))
+		}
+		listContainer.Refresh()
+	}
+
+	addBtn := widget.NewButtonWithIcon("Add Style", theme.ContentAddIcon(), func() {
+		showEditStyleDialog(NamedStyle{FontSize: "14", Align: "left"}, func(created NamedStyle) error {
+			if styleIndex(*styles, created.Name) >= 0 {
+				return fmt.Errorf("there is already a style called %s", created.Name)
+			}
+			*styles = append(*styles, created)
+			refreshList()
+			onChange()
+			return nil
+		}, w)
+	})
+
+	refreshList()
+
+	scroll := container.NewVScroll(listContainer)
+	scroll.SetMinSize(fyne.NewSize(250, 5*40))
+	dialog.ShowCustom(title, "Close", container.NewBorder(nil, addBtn, nil, nil, scroll), w)
+}
+
+func showEditStyleDialog(s NamedStyle, onSave func(NamedStyle) error, w fyne.Window) {
+	var editDialog *dialog.CustomDialog
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(s.Name)
+
+	fontSize := widget.NewEntry()
+	fontSize.SetText(s.FontSize)
+
+	bold := widget.NewCheck("Bold", nil)
+	bold.SetChecked(s.Bold)
+
+	italic := widget.NewCheck("Italic", nil)
+	italic.SetChecked(s.Italic)
+
+	underline := widget.NewCheck("Underline", nil)
+	underline.SetChecked(s.Underline)
+
+	alignSelect := widget.NewSelect([]string{"left", "center", "right"}, func(s string) {})
+	alignSelect.SetSelected(s.Align)
+
+	form := widget.NewForm(
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Font Size", fontSize),
+		widget.NewFormItem("Alignment", alignSelect),
+		widget.NewFormItem("", bold),
+		widget.NewFormItem("", italic),
+		widget.NewFormItem("", underline),
+	)
+
+	saveBtn := widget.NewButton("Save", func() {
+		if nameEntry.Text == "" {
+			dialog.ShowInformation("Missing Name", "Please enter a style name.", w)
+			return
+		}
+		fs := fontSize.Text
+		if fs != "fit" {
+			if _, err := strconv.Atoi(fs); err != nil {
+				fs = "14"
+			}
+		}
+		err := onSave(NamedStyle{
+			Name:      nameEntry.Text,
+			Bold:      bold.Checked,
+			Italic:    italic.Checked,
+			Underline: underline.Checked,
+			FontSize:  fs,
+			Align:     alignSelect.Selected,
+		})
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		editDialog.Hide()
+	})
+
+	editDialog = dialog.NewCustom("Edit Style", "Cancel", container.NewVBox(form, saveBtn), w)
+	editDialog.Resize(fyne.NewSize(300, 300))
+	editDialog.Show()
+}
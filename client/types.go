@@ -1,6 +1,12 @@
 package main
 
-import "fyne.io/fyne/v2"
+import (
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	lua "github.com/yuin/gopher-lua"
+)
 
 const (
 	TextComponent    ComponentType = "text"
@@ -11,8 +17,20 @@ const (
 	ImageComponent   ComponentType = "image"
 )
 
+var componentTypes = []ComponentType{
+	TextComponent,
+	DividerComponent,
+	QRComponent,
+	MacroComponent,
+	HeaderComponent,
+	ImageComponent,
+}
+
+// Component is one element of a layout. Every string field goes through
+// placeholder expansion when printing unless it is tagged expand:"-".
 type Component struct {
-	Type      ComponentType `json:"type"`
+	ID        string        `json:"id,omitempty" expand:"-"`
+	Type      ComponentType `json:"type" expand:"-"`
 	Name      string        `json:"name"`
 	Content   string        `json:"content,omitempty"`
 	Bold      bool          `json:"bold,omitempty"`
@@ -24,6 +42,10 @@ type Component struct {
 	Fit       bool          `json:"fit,omitempty"`
 	Scale     int           `json:"scale,omitempty"`
 	Width     int           `json:"width,omitempty"`
+	Style     string        `json:"style,omitempty" expand:"-"`
+	Overrides []string      `json:"overrides,omitempty"`
+	Repeat    string        `json:"repeat,omitempty" expand:"-"`
+	RepeatAs  string        `json:"repeat_as,omitempty" expand:"-"`
 }
 
 type ComponentWidget struct {
@@ -33,26 +55,152 @@ type ComponentWidget struct {
 }
 
 type Template struct {
-	Name   string      `json:"name"`
+	SchemaVersion int    `json:"schema_version"`
+	Name          string `json:"name"`
+	TemplateMetadata
+	Layout    []Component        `json:"layout"`
+	Variants  []TemplateVariant  `json:"variants,omitempty"`
+	Styles    []NamedStyle       `json:"styles,omitempty"`
+	Variables []TemplateVariable `json:"variables,omitempty"`
+}
+
+type TemplateMetadata struct {
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	ModifiedBy  string    `json:"modified_by,omitempty"`
+	Created     time.Time `json:"created,omitzero"`
+	Modified    time.Time `json:"modified,omitzero"`
+	Printer     string    `json:"printer,omitempty"`
+	Copies      int       `json:"copies,omitempty"`
+	Locale      string    `json:"locale,omitempty"`
+}
+
+// TemplateVariant is an alternative layout for printing a template in
+// another locale.
+type TemplateVariant struct {
+	Locale string      `json:"locale"`
 	Layout []Component `json:"layout"`
 }
 
+// TemplateVariable is a value which placeholders can refer to by name. It is
+// either filled in when a receipt is created, or worked out from Expression
+// before the receipt is expanded.
+type TemplateVariable struct {
+	Name       string `json:"name"`
+	Label      string `json:"label,omitempty"`
+	Default    string `json:"default,omitempty"`
+	Expression string `json:"expression,omitempty"`
+}
+
+type NamedStyle struct {
+	Name      string `json:"name"`
+	Bold      bool   `json:"bold,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	FontSize  string `json:"font_size,omitempty"`
+	Align     string `json:"align,omitempty"`
+}
+
 type AppSettings struct {
-	PrintServerURL string     `json:"print_server_url"`
-	PluginPath     string     `json:"plugins"`
-	Library        []Template `json:"library"`
+	SchemaVersion  int              `json:"schema_version"`
+	PrintServerURL string           `json:"print_server_url"`
+	PluginPath     string           `json:"plugins"`
+	UserName       string           `json:"user_name,omitempty"`
+	Printers       []PrinterProfile `json:"printers,omitempty"`
+	Library        []Template       `json:"library"`
+	Styles         []NamedStyle     `json:"styles,omitempty"`
+	// PluginConfig holds each plugin's settings by plugin name, except for
+	// secrets, which are kept in a separate file.
+	PluginConfig map[string]map[string]any `json:"plugin_config,omitempty"`
+	// ApprovedPermissions holds the permissions the user has allowed each
+	// plugin, by plugin name.
+	ApprovedPermissions map[string]PluginPermissions `json:"approved_permissions,omitempty"`
+	DisabledPlugins     []string                     `json:"disabled_plugins,omitempty"`
+}
+
+type PrinterProfile struct {
+	Name           string `json:"name"`
+	PrintServerURL string `json:"print_server_url"`
 }
 
 type PluginManifest struct {
-	PluginName string         `json:"name"`
-	Version    string         `json:"version"`
-	Functions  []FunctionInfo `json:"functions"`
+	PluginName  string            `json:"name"`
+	Version     string            `json:"version"`
+	Functions   []FunctionInfo    `json:"functions"`
+	Config      []ConfigField     `json:"config,omitempty"`
+	Permissions PluginPermissions `json:"permissions,omitzero"`
+	Limits      PluginLimits      `json:"limits,omitzero"`
+
+	// state is the Lua state the plugin was loaded into, and dir is the
+	// folder it was loaded from, or empty for embedded plugins. mu is held
+	// while a function runs in state, as a Lua state can only run one thing
+	// at a time.
+	state *lua.LState
+	dir   string
+	mu    sync.Mutex
+}
+
+// PluginLimits stop a plugin from hanging Receiptify or using up its memory.
+// Zero values use the defaults.
+type PluginLimits struct {
+	// Timeout is how many seconds a function can run for.
+	Timeout float64 `json:"timeout,omitempty"`
+	// CallDepth is how deeply Lua functions can call each other.
+	CallDepth int `json:"call_depth,omitempty"`
+	// Registry is how many values the Lua stack can hold, which limits the
+	// memory a plugin's calls can use.
+	Registry int `json:"registry,omitempty"`
+}
+
+// ConfigField is a setting a plugin can be given in the Settings screen. Type
+// is "string", "number" or "boolean", and secret settings such as API keys
+// aren't stored with the rest of the settings.
+type ConfigField struct {
+	Key         string `json:"key"`
+	Label       string `json:"label,omitempty"`
+	Type        string `json:"type"`
+	Default     any    `json:"default,omitempty"`
+	Secret      bool   `json:"secret,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 type FunctionInfo struct {
-	Name    string   `json:"name"`
-	Params  []string `json:"params"`
-	Returns []string `json:"returns"`
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Params      []ParamInfo  `json:"params"`
+	Returns     []ReturnInfo `json:"returns"`
+	// Preview names a function to call instead while previewing, which
+	// returns realistic values without changing anything.
+	Preview string `json:"preview,omitempty"`
+}
+
+// ParamInfo describes one argument of a plugin function. In a manifest it can
+// be just the type, such as "string", for a required argument.
+type ParamInfo struct {
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	// Optional arguments can be left out of a call, and must come after the
+	// required ones. An argument with a Default is optional.
+	Optional bool  `json:"optional,omitempty"`
+	Default  any   `json:"default,omitempty"`
+	Enum     []any `json:"enum,omitempty"`
+	// Items is the type of each item of a list argument, if they all have
+	// the same type.
+	Items string `json:"items,omitempty"`
+}
+
+// ReturnInfo describes one value a plugin function returns. In a manifest it
+// can be just the type.
+type ReturnInfo struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	// Precision writes a number return with that many decimal places.
+	Precision *int `json:"precision,omitempty"`
+	// Format writes a date return with strftime directives, rather than in
+	// the locale's date format.
+	Format string `json:"format,omitempty"`
 }
 
 type ComponentType string
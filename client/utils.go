@@ -1,6 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
@@ -28,3 +34,59 @@ func MakeDarkLabel(text string) fyne.CanvasObject {
 	bg := canvas.NewRectangle(theme.Color(theme.ColorNameBackground))
 	return container.NewStack(bg, label)
 }
+
+const fileLockTimeout = 5 * time.Second
+
+// withFileLock runs fn while holding a lock file next to path, so other copies
+// of Receiptify don't change the file at the same time.
+func withFileLock(path string, fn func() error) error {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			lock.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		// A lock left behind by a copy of Receiptify which crashed.
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > fileLockTimeout {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to be unlocked", filepath.Base(path))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer os.Remove(lockPath)
+	return fn()
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so the file is never left half written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
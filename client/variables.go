@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const variableNamePattern = "^[A-Za-z_][A-Za-z0-9_]*$"
+
+var variableNameRegexp = regexp.MustCompile(variableNamePattern)
+
+func validVariableName(name string) error {
+	if !variableNameRegexp.MatchString(name) {
+		return fmt.Errorf("variable names must start with a letter or underscore and contain only letters, digits and underscores")
+	}
+	if slices.Contains(keywords, name) || name == stdNamespace {
+		return fmt.Errorf("%s is a reserved word", name)
+	}
+	return nil
+}
+
+func findVariable(name string, variables []TemplateVariable) (TemplateVariable, bool) {
+	for _, v := range variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return TemplateVariable{}, false
+}
+
+// variableEnv builds the environment for expanding a receipt from the values
+// filled in for each variable, falling back to the variable's default.
+// Variables with an expression are worked out in order, so they can use the
+// variables before them.
+func variableEnv(ctx context.Context, variables []TemplateVariable, values map[string]string) (*exprEnv, error) {
+	env := &exprEnv{Vars: map[string]any{}, ctx: ctx}
+	// Plugins called while working out the variables can read the ones
+	// before them.
+	if job := hostJobFrom(ctx); job != nil {
+		job.Variables = env.Vars
+	}
+	for _, v := range variables {
+		if v.Expression != "" {
+			expr, err := parseSource(v.Expression)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s: %v", v.Name, err)
+			}
+			value, err := evalExpr(expr, env)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s: %v", v.Name, err)
+			}
+			env.Vars[v.Name] = value
+			continue
+		}
+
+		value, ok := values[v.Name]
+		if !ok {
+			value = v.Default
+		}
+		env.Vars[v.Name] = value
+	}
+	return env, nil
+}
+
+func variableLabel(v TemplateVariable) string {
+	if v.Label != "" {
+		return v.Label
+	}
+	return v.Name
+}
+
+func showVariablesDialog(variables *[]TemplateVariable, onChange func(), w fyne.Window) {
+	listContainer := container.NewVBox()
+
+	var refreshList func()
+	refreshList = func() {
+		listContainer.Objects = nil
+		for i, v := range *variables {
+			idx := i
+			nameBtn := widget.NewButton(v.Name, func() {
+				showEditVariableDialog((*variables)[idx], func(updated TemplateVariable) error {
+					if updated.Name != (*variables)[idx].Name {
+						if _, exists := findVariable(updated.Name, *variables); exists {
+							return fmt.Errorf("there is already a variable called %s", updated.Name)
+						}
+					}
+					(*variables)[idx] = updated
+					refreshList()
+					onChange()
+					return nil
+				}, w)
+			})
+			nameBtn.Alignment = widget.ButtonAlignLeading
+
+			deleteBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				*variables = append((*variables)[:idx], (*variables)[idx+1:]...)
+				refreshList()
+				onChange()
+			})
+
+			listContainer.Add(container.NewBorder(nil, nil, nil, deleteBtn, nameBtn))
+		}
+		if len(*variables) == 0 {
+			listContainer.Add(widget.NewLabel("No variables defined."))
+		}
+		listContainer.Refresh()
+	}
+
+	addBtn := widget.NewButtonWithIcon("Add Variable", theme.ContentAddIcon(), func() {
+		showEditVariableDialog(TemplateVariable{}, func(created TemplateVariable) error {
+			if _, exists := findVariable(created.Name, *variables); exists {
+				return fmt.Errorf("there is already a variable called %s", created.Name)
+			}
+			*variables = append(*variables, created)
+			refreshList()
+			onChange()
+			return nil
+		}, w)
+	})
+
+	refreshList()
+
+	scroll := container.NewVScroll(listContainer)
+	scroll.SetMinSize(fyne.NewSize(250, 5*40))
+	dialog.ShowCustom("Template Variables", "Close", container.NewBorder(nil, addBtn, nil, nil, scroll), w)
+}
+
+func showEditVariableDialog(v TemplateVariable, onSave func(TemplateVariable) error, w fyne.Window) {
+	var editDialog *dialog.CustomDialog
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetText(v.Name)
+
+	labelEntry := widget.NewEntry()
+	labelEntry.SetPlaceHolder("Shown in the Receipt Creator")
+	labelEntry.SetText(v.Label)
+
+	defaultEntry := widget.NewEntry()
+	defaultEntry.SetText(v.Default)
+
+	expressionEntry := widget.NewEntry()
+	expressionEntry.SetPlaceHolder("e.g. Shop.order(order_id)")
+	expressionEntry.SetText(v.Expression)
+
+	form := widget.NewForm(
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Label", labelEntry),
+		widget.NewFormItem("Default", defaultEntry),
+		widget.NewFormItem("Expression", expressionEntry),
+	)
+	form.Items[3].HintText = "Leave empty to fill in when printing"
+
+	saveBtn := widget.NewButton("Save", func() {
+		if err := validVariableName(nameEntry.Text); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if expressionEntry.Text != "" {
+			if _, err := parseSource(expressionEntry.Text); err != nil {
+				dialog.ShowError(fmt.Errorf("expression: %v", err), w)
+				return
+			}
+		}
+		err := onSave(TemplateVariable{
+			Name:       nameEntry.Text,
+			Label:      labelEntry.Text,
+			Default:    defaultEntry.Text,
+			Expression: expressionEntry.Text,
+		})
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		editDialog.Hide()
+	})
+
+	editDialog = dialog.NewCustom("Edit Variable", "Cancel", container.NewVBox(form, saveBtn), w)
+	editDialog.Resize(fyne.NewSize(350, 300))
+	editDialog.Show()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"github.com/fsnotify/fsnotify"
+)
+
+// pluginReloadDelay lets a burst of changes, such as an editor saving
+// several files, finish before the plugin is reloaded.
+const pluginReloadDelay = 300 * time.Millisecond
+
+var (
+	pluginWatcher   *fsnotify.Watcher
+	pluginWatcherMu sync.Mutex
+)
+
+// watchPlugins reloads a plugin from the plugin path whenever its manifest or
+// Lua files change. Calling it again starts watching settings.PluginPath
+// afresh.
+func watchPlugins(w fyne.Window) {
+	pluginWatcherMu.Lock()
+	defer pluginWatcherMu.Unlock()
+
+	if pluginWatcher != nil {
+		pluginWatcher.Close()
+		pluginWatcher = nil
+	}
+	if settings.PluginPath == "" {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fyne.LogError("Could not watch plugins for changes", err)
+		return
+	}
+	pluginWatcher = watcher
+	root := settings.PluginPath
+	addPluginWatches(watcher, root)
+
+	go func() {
+		timers := map[string]*time.Timer{}
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						addPluginWatches(watcher, event.Name)
+					}
+				}
+
+				pluginPath, ok := changedPlugin(root, event.Name)
+				if !ok {
+					continue
+				}
+				if timer, ok := timers[pluginPath]; ok {
+					timer.Stop()
+				}
+				timers[pluginPath] = time.AfterFunc(pluginReloadDelay, func() {
+					fyne.Do(func() {
+						reloadChangedPlugin(pluginPath, w)
+					})
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fyne.LogError("Error watching plugins", err)
+			}
+		}
+	}()
+}
+
+// addPluginWatches watches a folder and the folders inside it, apart from
+// plugins' data folders, which plugins write to as they run.
+func addPluginWatches(watcher *fsnotify.Watcher, dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == "data" && path != dir {
+			return filepath.SkipDir
+		}
+		watcher.Add(path)
+		return nil
+	})
+}
+
+// changedPlugin works out which plugin folder a changed file belongs to, if
+// the change means the plugin needs reloading.
+func changedPlugin(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if strings.HasPrefix(parts[0], ".") {
+		return "", false
+	}
+	if len(parts) == 1 {
+		// A whole plugin folder was added or removed.
+		return path, true
+	}
+	if parts[1] == "data" {
+		return "", false
+	}
+	name := parts[len(parts)-1]
+	if name != "manifest.json" && filepath.Ext(name) != ".lua" {
+		return "", false
+	}
+	return filepath.Join(root, parts[0]), true
+}
+
+// loadedFrom finds the plugin loaded from a folder.
+func loadedFrom(pluginPath string) *PluginManifest {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	for _, manifest := range manifests {
+		if manifest.dir == pluginPath {
+			return manifest
+		}
+	}
+	return nil
+}
+
+// reloadChangedPlugin reloads a plugin and tells the user how it went,
+// without getting in their way. It must be called on the UI goroutine.
+func reloadChangedPlugin(pluginPath string, w fyne.Window) {
+	before := loadedFrom(pluginPath)
+	err := reloadPluginFromDisk(pluginPath)
+	after := loadedFrom(pluginPath)
+
+	var notification *fyne.Notification
+	switch {
+	case err != nil:
+		notification = fyne.NewNotification("Plugin failed to reload", err.Error())
+	case after != nil:
+		notification = fyne.NewNotification("Plugin reloaded", after.PluginName+" "+after.Version+" was reloaded.")
+	case before != nil:
+		notification = fyne.NewNotification("Plugin removed", before.PluginName+" was unloaded.")
+	default:
+		return
+	}
+	fyne.CurrentApp().SendNotification(notification)
+	askPluginPermissions(w)
+}